@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// AdaptiveConfig samples a target every FastInterval instead of every
+// interval while its cpu or rss changes quickly, until Hold after the last
+// such change.
+type AdaptiveConfig struct {
+	// FastInterval defaults to a tenth of the interval, at least 100ms.
+	FastInterval string `json:"fast_interval,omitempty"`
+	// CPUChange is in percentage points of one core between two samples,
+	// 25 by default; one clock tick in 100ms is already 10.
+	CPUChange float64 `json:"cpu_change,omitempty"`
+	// RSSChange is in percent of the previous rss, 10 by default.
+	RSSChange float64 `json:"rss_change,omitempty"`
+	// Hold defaults to 10s.
+	Hold string `json:"hold,omitempty"`
+}
+
+type adaptiveSampling struct {
+	fast      time.Duration
+	hold      time.Duration
+	cpuChange float64
+	rssChange float64
+}
+
+// adaptive is nil unless the config has "adaptive".
+var adaptive *adaptiveSampling
+
+func parseAdaptive(cfg *AdaptiveConfig, interval time.Duration) (*adaptiveSampling, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	a := &adaptiveSampling{fast: interval / 10, hold: 10 * time.Second, cpuChange: 25, rssChange: 10}
+	if a.fast < minInterval {
+		a.fast = minInterval
+	}
+	if cfg.FastInterval != "" {
+		d, err := time.ParseDuration(cfg.FastInterval)
+		if err != nil || d < minInterval {
+			return nil, fmt.Errorf("adaptive: bad fast_interval %q, at least %v", cfg.FastInterval, minInterval)
+		}
+		a.fast = d
+	}
+	if a.fast >= interval {
+		return nil, fmt.Errorf("adaptive: fast_interval %v must be shorter than the interval %v", a.fast, interval)
+	}
+	if cfg.Hold != "" {
+		d, err := time.ParseDuration(cfg.Hold)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("adaptive: bad hold %q", cfg.Hold)
+		}
+		a.hold = d
+	}
+	if cfg.CPUChange < 0 || cfg.RSSChange < 0 {
+		return nil, fmt.Errorf("adaptive: cpu_change and rss_change must be positive")
+	}
+	if cfg.CPUChange > 0 {
+		a.cpuChange = cfg.CPUChange
+	}
+	if cfg.RSSChange > 0 {
+		a.rssChange = cfg.RSSChange
+	}
+	return a, nil
+}
+
+// tickInterval is how often the collection loop wakes up. Callers hold
+// statsLock.
+func tickInterval() time.Duration {
+	if adaptive != nil {
+		return adaptive.fast
+	}
+	return collectInterval
+}
+
+// isDue reports whether ps is collected in the cycle at now: when it is
+// sampled fast or its interval has come, give or take half a tick. Callers
+// hold statsLock.
+func isDue(ps *ProcessStats, now time.Time) bool {
+	if adaptive == nil || now.Before(ps.fastUntil) {
+		return true
+	}
+	return now.Sub(ps.LastScrape) >= collectInterval-adaptive.fast/2
+}
+
+// updateAdaptive starts or extends fast sampling of name when s changed
+// quickly from prev. Both are normalized to the interval. Callers hold
+// statsLock.
+func updateAdaptive(name string, ps *ProcessStats, prev, s Sample) {
+	if adaptive == nil || prev.Time == 0 {
+		return
+	}
+	now := time.Unix(0, s.Time*int64(time.Millisecond))
+	// ticks in an interval to percent of a core
+	scale := 100 / (clkTck * collectInterval.Seconds())
+	cpu := math.Abs(float64(s.Metrics["cpu"]-prev.Metrics["cpu"])) * scale
+	var rss float64
+	if p := prev.Metrics["rss"]; p > 0 {
+		rss = math.Abs(float64(s.Metrics["rss"]-p)) / float64(p) * 100
+	}
+	if cpu > adaptive.cpuChange || rss > adaptive.rssChange {
+		if !now.Before(ps.fastUntil) {
+			fmt.Println(name, "changing quickly, sampling every", adaptive.fast)
+		}
+		ps.fastUntil = now.Add(adaptive.hold)
+	} else if !ps.fastUntil.IsZero() && !now.Before(ps.fastUntil) {
+		fmt.Println(name, "settled, sampling every", collectInterval)
+		ps.fastUntil = time.Time{}
+	}
+}
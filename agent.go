@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The agent mode sends the samples to a central exporter started with
+// -receive, for hosts that can't be scraped. Samples are sent every
+// -push-interval; while the receiver is unreachable they are kept, up to
+// the retention per target, and sent with backoff.
+var (
+	pushURL      string
+	pushToken    string
+	pushInterval = 10 * time.Second
+	receiveMode  bool
+	agentCycles  = make(chan map[string]Sample, 64)
+	agentClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Limits of what the receiver accepts, so that a misbehaving agent can't
+// run it out of memory.
+const (
+	maxPushBytes   = 32 << 20
+	maxPushHosts   = 1000
+	maxPushTargets = 1000
+)
+
+// AgentPush is the body POSTed to /api/v1/push.
+type AgentPush struct {
+	Host    string              `json:"host"`
+	Samples map[string][]Sample `json:"samples"`
+}
+
+func queueAgentCycle(cycle map[string]Sample) {
+	if pushURL == "" || len(cycle) == 0 {
+		return
+	}
+	select {
+	case agentCycles <- cycle:
+	default:
+		fmt.Println("agent push is behind, dropped a cycle")
+	}
+}
+
+// RunAgent batches the collected samples and POSTs them to pushURL.
+func RunAgent() {
+	pending := make(map[string][]Sample)
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+	var backoff time.Duration
+	var next time.Time
+	lastError := ""
+	for {
+		select {
+		case cycle := <-agentCycles:
+			statsLock.RLock()
+			retention := maxSamples
+			statsLock.RUnlock()
+			for name, s := range cycle {
+				samples := append(pending[name], s)
+				if len(samples) > retention {
+					samples = samples[len(samples)-retention:]
+				}
+				pending[name] = samples
+			}
+			continue
+		case now := <-ticker.C:
+			if len(pending) == 0 || now.Before(next) {
+				continue
+			}
+		}
+		if err := sendAgentPush(AgentPush{Host: hostname, Samples: pending}); err != nil {
+			backoff *= 2
+			if backoff == 0 {
+				backoff = pushInterval
+			}
+			if backoff > 5*time.Minute {
+				backoff = 5 * time.Minute
+			}
+			next = time.Now().Add(backoff)
+			if err.Error() != lastError {
+				fmt.Println("agent push:", err, "retrying in", backoff)
+			}
+			lastError = err.Error()
+			continue
+		}
+		if lastError != "" {
+			fmt.Println("agent push: sent the samples held back")
+		}
+		pending = make(map[string][]Sample)
+		backoff, lastError = 0, ""
+	}
+}
+
+func sendAgentPush(p AgentPush) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if pushToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pushToken)
+	}
+	// user:password@ in pushURL is sent as basic auth
+	resp, err := agentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", pushURL, resp.Status)
+	}
+	return nil
+}
+
+// pushHandler serves POST /api/v1/push for agents when started with
+// -receive, which requires auth. The pushed targets show up like those of
+// federation peers. Samples not newer than the last one of their target are
+// dropped, so an agent retrying a push whose response it lost doesn't add
+// them twice.
+func pushHandler(w http.ResponseWriter, req *http.Request) {
+	if !receiveMode || !authConfigured() {
+		http.Error(w, "receiving pushes is disabled, see -receive", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var p AgentPush
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxPushBytes)).Decode(&p); err != nil || p.Host == "" {
+		http.Error(w, `expected {"host": "...", "samples": {...}} of at most 32MiB`, http.StatusBadRequest)
+		return
+	}
+	if !validLabelValue(p.Host) {
+		http.Error(w, fmt.Sprintf("bad host %q", p.Host), http.StatusBadRequest)
+		return
+	}
+	if err := checkSamples(p.Samples); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	statsLock.RLock()
+	retention := maxSamples
+	statsLock.RUnlock()
+	federationLock.Lock()
+	defer federationLock.Unlock()
+	key := "push:" + p.Host
+	st := peerStates[key]
+	if st == nil {
+		pushed := 0
+		for k := range peerStates {
+			if strings.HasPrefix(k, "push:") {
+				pushed++
+			}
+		}
+		if pushed >= maxPushHosts {
+			http.Error(w, fmt.Sprintf("more than %d hosts pushing", maxPushHosts), http.StatusBadRequest)
+			return
+		}
+		st = &peerState{host: p.Host, series: make(map[string][]Sample)}
+	}
+	targets := len(st.series)
+	for name := range p.Samples {
+		if st.series[name] == nil {
+			targets++
+		}
+	}
+	if targets > maxPushTargets {
+		http.Error(w, fmt.Sprintf("more than %d targets for %s", maxPushTargets, p.Host), http.StatusBadRequest)
+		return
+	}
+	peerStates[key] = st
+	st.since = time.Now().UnixNano() / int64(time.Millisecond)
+	for name, pushed := range p.Samples {
+		samples := st.series[name]
+		var last int64
+		if len(samples) > 0 {
+			last = samples[len(samples)-1].Time
+		}
+		for _, s := range pushed {
+			if s.Time > last {
+				samples = append(samples, s)
+				last = s.Time
+			}
+		}
+		if len(samples) > retention {
+			samples = samples[len(samples)-retention:]
+		}
+		st.series[name] = samples
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
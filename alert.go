@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertRule is parsed from a line such as
+//
+//	process=postgres metric=rss op=> value=2GiB for=60s
+type AlertRule struct {
+	Text    string
+	Process string
+	Metric  string
+	Op      string
+	Value   int64
+	For     time.Duration
+
+	pendingSince time.Time
+	firing       bool
+}
+
+type AlertEvent struct {
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Process   string    `json:"process"`
+	Pid       int       `json:"pid"`
+	Metric    string    `json:"metric"`
+	Op        string    `json:"op"`
+	Threshold int64     `json:"threshold"`
+	Value     int64     `json:"value"`
+	For       string    `json:"for"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+
+	Labels  map[string]string `json:"labels"`
+	Metrics map[string]int64  `json:"metrics"`
+}
+
+var hostname, _ = os.Hostname()
+
+func eventLabels(processName string) map[string]string {
+	labels := map[string]string{
+		"process": processName,
+		"host":    hostname,
+	}
+	for k, v := range TargetLabels(processName) {
+		labels[k] = v
+	}
+	return labels
+}
+
+var alertRules []*AlertRule
+
+var unitSuffixes = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+}
+
+func parseValue(s string) (int64, error) {
+	mult := int64(1)
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			mult = u.mult
+			s = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// alertTemplates are rules for common conditions, used as
+//
+//	template=oom_score process=postgres
+//
+// Fields given with the template override its defaults.
+var alertTemplates = map[string]AlertRule{
+	// the process is among the first the OOM killer would pick
+	"oom_score": {Metric: "oom_score", Op: ">", Value: 800, For: time.Minute},
+}
+
+func ParseAlertRule(text string) (*AlertRule, error) {
+	r := &AlertRule{Text: text}
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		if !strings.HasPrefix(field, "template=") {
+			continue
+		}
+		t, ok := alertTemplates[strings.TrimPrefix(field, "template=")]
+		if !ok {
+			return nil, fmt.Errorf("alert rule %q: unknown template %q", text, strings.TrimPrefix(field, "template="))
+		}
+		r.Metric, r.Op, r.Value, r.For = t.Metric, t.Op, t.Value, t.For
+		fields = append(fields[:i:i], fields[i+1:]...)
+		break
+	}
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("alert rule %q: bad field %q", text, field)
+		}
+		var err error
+		switch kv[0] {
+		case "process":
+			r.Process = kv[1]
+		case "metric":
+			r.Metric = kv[1]
+		case "op":
+			r.Op = kv[1]
+		case "value":
+			r.Value, err = parseValue(kv[1])
+		case "for":
+			r.For, err = time.ParseDuration(kv[1])
+		default:
+			err = fmt.Errorf("unknown key %q", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %v", text, err)
+		}
+	}
+	if r.Process == "" || r.Metric == "" {
+		return nil, fmt.Errorf("alert rule %q: process and metric are required", text)
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("alert rule %q: unknown op %q", text, r.Op)
+	}
+	return r, nil
+}
+
+func (r *AlertRule) matches(v int64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Value
+	case ">=":
+		return v >= r.Value
+	case "<":
+		return v < r.Value
+	case "<=":
+		return v <= r.Value
+	case "==":
+		return v == r.Value
+	case "!=":
+		return v != r.Value
+	}
+	return false
+}
+
+func (r *AlertRule) event(status string, s Sample) AlertEvent {
+	return AlertEvent{
+		Status:    status,
+		Rule:      r.Text,
+		Process:   r.Process,
+		Pid:       s.Pid,
+		Metric:    r.Metric,
+		Op:        r.Op,
+		Threshold: r.Value,
+		Value:     s.Metrics[r.Metric],
+		For:       r.For.String(),
+		Since:     r.pendingSince,
+		Time:      time.Unix(0, s.Time*int64(time.Millisecond)),
+		Labels:    eventLabels(r.Process),
+		Metrics:   s.Metrics,
+	}
+}
+
+// evaluateAlerts is called with every new sample. A rule fires once its
+// condition has held continuously for the rule's duration and resolves as
+// soon as the condition stops holding.
+func evaluateAlerts(processName string, s Sample) {
+	now := time.Unix(0, s.Time*int64(time.Millisecond))
+	for _, r := range alertRules {
+		if r.Process != processName {
+			continue
+		}
+		v, ok := s.Metrics[r.Metric]
+		if !ok {
+			continue
+		}
+		if r.matches(v) {
+			if r.pendingSince.IsZero() {
+				r.pendingSince = now
+			}
+			if !r.firing && now.Sub(r.pendingSince) >= r.For {
+				r.firing = true
+				go sendAlert(r.event("firing", s))
+			}
+		} else {
+			if r.firing {
+				r.firing = false
+				go sendAlert(r.event("resolved", s))
+			}
+			r.pendingSince = time.Time{}
+		}
+	}
+}
+
+func sendAlert(e AlertEvent) {
+	fmt.Println("alert", e.Status+":", e.Rule, "value:", e.Value)
+	statsLock.RLock()
+	ns := notifiers
+	statsLock.RUnlock()
+	for _, n := range ns {
+		if err := n.Notify(e); err != nil {
+			fmt.Println("notify:", err)
+		}
+	}
+}
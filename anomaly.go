@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnomalyConfig enables the EWMA based detector. A sample is anomalous when
+// it is more than Sigma standard deviations away from the moving average of
+// the same process and metric.
+type AnomalyConfig struct {
+	Metrics []string `json:"metrics"`
+	Sigma   float64  `json:"sigma"`
+	Alpha   float64  `json:"alpha"`
+	Warmup  int      `json:"warmup"`
+}
+
+type Anomaly struct {
+	Process string  `json:"process"`
+	Metric  string  `json:"metric"`
+	Time    int64   `json:"time"`
+	Value   int64   `json:"value"`
+	Mean    float64 `json:"mean"`
+	Stddev  float64 `json:"stddev"`
+}
+
+type ewma struct {
+	mean, variance float64
+	n              int
+	firing         bool
+	since          time.Time
+}
+
+const maxAnomalies = 300
+
+func (c *AnomalyConfig) setDefaults() {
+	if c.Sigma == 0 {
+		c.Sigma = 3
+	}
+	if c.Alpha == 0 {
+		c.Alpha = 0.1
+	}
+	if c.Warmup == 0 {
+		c.Warmup = 30
+	}
+}
+
+var (
+	anomalyConfig *AnomalyConfig
+	anomalyLock   sync.Mutex
+	anomalyState  = make(map[string]*ewma)
+	anomalies     []Anomaly
+)
+
+// resetAnomalies drops the baselines of a process, which start over after
+// it (re)starts: its first deltas are zero and it may behave differently.
+func resetAnomalies(processName string) {
+	anomalyLock.Lock()
+	defer anomalyLock.Unlock()
+	for key := range anomalyState {
+		if strings.HasPrefix(key, processName+"/") {
+			delete(anomalyState, key)
+		}
+	}
+}
+
+func detectAnomalies(processName string, s Sample) {
+	if anomalyConfig == nil {
+		return
+	}
+	cfg := anomalyConfig
+	now := time.Unix(0, s.Time*int64(time.Millisecond))
+	anomalyLock.Lock()
+	defer anomalyLock.Unlock()
+	for _, metric := range cfg.Metrics {
+		v, ok := s.Metrics[metric]
+		if !ok {
+			continue
+		}
+		key := processName + "/" + metric
+		st := anomalyState[key]
+		if st == nil {
+			st = &ewma{mean: float64(v)}
+			anomalyState[key] = st
+		}
+		x := float64(v)
+		stddev := math.Sqrt(st.variance)
+		anomalous := st.n >= cfg.Warmup && stddev > 0 && math.Abs(x-st.mean) > cfg.Sigma*stddev
+		if anomalous {
+			anomalies = append(anomalies, Anomaly{
+				Process: processName,
+				Metric:  metric,
+				Time:    s.Time,
+				Value:   v,
+				Mean:    st.mean,
+				Stddev:  stddev,
+			})
+			if len(anomalies) > maxAnomalies {
+				anomalies = anomalies[len(anomalies)-maxAnomalies:]
+			}
+		}
+		if anomalous != st.firing {
+			st.firing = anomalous
+			status := "resolved"
+			if anomalous {
+				status = "firing"
+				st.since = now
+			}
+			op, bound := ">", st.mean+cfg.Sigma*stddev
+			if x < st.mean {
+				op, bound = "<", st.mean-cfg.Sigma*stddev
+			}
+			go sendAlert(AlertEvent{
+				Status:    status,
+				Rule:      fmt.Sprintf("anomaly process=%s metric=%s sigma=%g", processName, metric, cfg.Sigma),
+				Process:   processName,
+				Pid:       s.Pid,
+				Metric:    metric,
+				Op:        op,
+				Threshold: int64(bound),
+				Value:     v,
+				For:       "0s",
+				Since:     st.since,
+				Time:      now,
+				Labels:    eventLabels(processName),
+				Metrics:   s.Metrics,
+			})
+		}
+
+		diff := x - st.mean
+		incr := cfg.Alpha * diff
+		st.mean += incr
+		st.variance = (1 - cfg.Alpha) * (st.variance + diff*incr)
+		st.n++
+	}
+}
+
+func GetAnomalies() []Anomaly {
+	anomalyLock.Lock()
+	defer anomalyLock.Unlock()
+	result := make([]Anomaly, len(anomalies))
+	copy(result, anomalies)
+	return result
+}
+
+func anomaliesHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetAnomalies())
+}
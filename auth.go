@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// basicAuthUsers maps user names to passwords as stored in an htpasswd
+// file: bcrypt ("$2y$", htpasswd -B), "$apr1$" (htpasswd -m) and "{SHA}"
+// hashes, or plain text. No auth is required when it is empty.
+var basicAuthUsers = make(map[string]string)
+
+// bearerTokens are accepted in place of basic auth where tokenAllowed.
+var bearerTokens []string
+
+func LoadTokens(filename string) ([]string, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(dat), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", filename)
+	}
+	return tokens, nil
+}
+
+func authConfigured() bool {
+	return len(basicAuthUsers) > 0 || len(bearerTokens) > 0
+}
+
+func checkBearerToken(req *http.Request) bool {
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimSpace(h[len("Bearer "):]))
+	ok := false
+	for _, t := range bearerTokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func checkBasicAuth(req *http.Request) bool {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	stored, found := basicAuthUsers[user]
+	return found && checkPassword(stored, password)
+}
+
+func LoadHtpasswd(filename string) (map[string]string, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]string)
+	for i, line := range strings.Split(string(dat), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected user:password", filename, i+1)
+		}
+		if strings.HasPrefix(kv[1], "$") && !isBcrypt(kv[1]) && !strings.HasPrefix(kv[1], "$apr1$") {
+			return nil, fmt.Errorf("%s:%d: only bcrypt, $apr1$, {SHA} and plain text passwords are supported", filename, i+1)
+		}
+		users[kv[0]] = kv[1]
+	}
+	return users, nil
+}
+
+func ReadPasswordFile(filename string) (string, error) {
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(dat), "\r\n"), nil
+}
+
+func isBcrypt(stored string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPassword(stored string, password string) bool {
+	switch {
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$apr1$"):
+		salt := strings.SplitN(stored[len("$apr1$"):], "$", 2)[0]
+		password = apr1(password, salt)
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		password = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// apr1 is Apache's variant of the MD5 based crypt(3), as written by
+// htpasswd -m.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(salt))
+	h.Write(pw)
+	alt := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic + salt))
+	for n := len(pw); n > 0; n -= 16 {
+		if n > 16 {
+			h.Write(alt)
+		} else {
+			h.Write(alt[:n])
+		}
+	}
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 == 1 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 == 1 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	out := []byte(magic + salt + "$")
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+	return string(out)
+}
+
+// tokenAllowed reports whether a bearer token may be used for req: reads
+// of the scrape endpoints and the API, including the POSTs that only query,
+// and pushes of agents. The dashboard pages, the config and anything that
+// changes the exporter, e.g. signals or targets, need basic auth.
+func tokenAllowed(req *http.Request) bool {
+	path := req.URL.Path
+	read := req.Method == http.MethodGet || req.Method == http.MethodHead
+	switch {
+	case path == "/metrics" || path == "/prometheus":
+		return read
+	case path == "/api/v1/config":
+		return false
+	case path == "/api/v1/push" || path == "/api/v1/query" || strings.HasPrefix(path, "/api/v1/grafana/"):
+		return read || req.Method == http.MethodPost
+	case strings.HasPrefix(path, "/api/v1/"):
+		return read
+	}
+	return false
+}
+
+// requireAuth checks every path once any auth is configured, accepting
+// basic auth when users are configured and bearer tokens when tokens are,
+// for the paths they are allowed on.
+func requireAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		basic := len(basicAuthUsers) > 0
+		token := len(bearerTokens) > 0
+		if !authConfigured() || (basic && checkBasicAuth(req)) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if token && checkBearerToken(req) {
+			if tokenAllowed(req) {
+				h.ServeHTTP(w, req)
+				return
+			}
+			http.Error(w, "bearer tokens only read /metrics, /prometheus and /api/v1/, log in for the rest", http.StatusForbidden)
+			return
+		}
+		if basic {
+			w.Header().Add("WWW-Authenticate", `Basic realm="linux-proc-exporter"`)
+		}
+		if token {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="linux-proc-exporter"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	oldUsers, oldTokens := basicAuthUsers, bearerTokens
+	defer func() { basicAuthUsers, bearerTokens = oldUsers, oldTokens }()
+	h := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	paths := []string{"/", "/metrics", "/api/v1/query", "/inventory", "/targets", "/process/nginx", "/depmap"}
+	// what a token may read of paths
+	tokenPaths := map[string]bool{"/metrics": true, "/api/v1/query": true}
+	tests := []struct {
+		name   string
+		users  map[string]string
+		tokens []string
+		auth   func(req *http.Request)
+		// want is for every path, wantToken for those in tokenPaths
+		want, wantToken int
+	}{
+		{"no auth configured", nil, nil, func(*http.Request) {}, http.StatusOK, http.StatusOK},
+		{"token only, none sent", nil, []string{"t0k"}, func(*http.Request) {}, http.StatusUnauthorized, http.StatusUnauthorized},
+		{"token only, token sent", nil, []string{"t0k"}, func(req *http.Request) { req.Header.Set("Authorization", "Bearer t0k") }, http.StatusForbidden, http.StatusOK},
+		{"token only, wrong token", nil, []string{"t0k"}, func(req *http.Request) { req.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, http.StatusUnauthorized},
+		{"users, none sent", map[string]string{"admin": "pw"}, nil, func(*http.Request) {}, http.StatusUnauthorized, http.StatusUnauthorized},
+		{"users, basic sent", map[string]string{"admin": "pw"}, nil, func(req *http.Request) { req.SetBasicAuth("admin", "pw") }, http.StatusOK, http.StatusOK},
+		{"both, token sent", map[string]string{"admin": "pw"}, []string{"t0k"}, func(req *http.Request) { req.Header.Set("Authorization", "Bearer t0k") }, http.StatusForbidden, http.StatusOK},
+	}
+	for _, tt := range tests {
+		basicAuthUsers, bearerTokens = tt.users, tt.tokens
+		if basicAuthUsers == nil {
+			basicAuthUsers = make(map[string]string)
+		}
+		for _, path := range paths {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			tt.auth(req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			want := tt.want
+			if tokenPaths[path] {
+				want = tt.wantToken
+			}
+			if rec.Code != want {
+				t.Errorf("%s: %s returned %d, want %d", tt.name, path, rec.Code, want)
+			}
+		}
+	}
+}
+
+func TestTokenAllowed(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/metrics", true},
+		{"GET", "/prometheus", true},
+		{"GET", "/api/v1/summary", true},
+		{"POST", "/api/v1/query", true},
+		{"POST", "/api/v1/push", true},
+		{"POST", "/api/v1/grafana/query", true},
+		{"GET", "/api/v1/config", false},
+		{"PUT", "/api/v1/config", false},
+		{"PUT", "/api/v1/metrics", false},
+		{"POST", "/api/v1/processes", false},
+		{"POST", "/api/v1/processes/nginx/signal", false},
+		{"GET", "/", false},
+		{"GET", "/debug/state", false},
+		{"POST", "/-/reload", false},
+	}
+	for _, tt := range tests {
+		if got := tokenAllowed(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// htpasswd -B writes $2y$, which is $2b$ under another name
+	bcrypt2y := "$2y$" + string(hash[4:])
+	for _, stored := range []string{
+		string(hash),
+		bcrypt2y,
+		"$apr1$r31uJ9Ki$7qtRP/uaLilC/w1pHh4RA.", // openssl passwd -apr1
+		"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"secret",
+	} {
+		if !checkPassword(stored, "secret") {
+			t.Errorf("%s: right password refused", stored)
+		}
+		if checkPassword(stored, "Secret") {
+			t.Errorf("%s: wrong password accepted", stored)
+		}
+	}
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	ioutil.WriteFile(file, []byte("a:$2y$05$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui\nb:$apr1$r31uJ9Ki$7qtRP/uaLilC/w1pHh4RA.\n"), 0600)
+	users, err := LoadHtpasswd(file)
+	if err != nil || len(users) != 2 {
+		t.Errorf("LoadHtpasswd = %v, %v", users, err)
+	}
+	ioutil.WriteFile(file, []byte("c:$6$salt$hash\n"), 0600)
+	if _, err := LoadHtpasswd(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Errorf("sha512-crypt accepted: %v", err)
+	}
+}
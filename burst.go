@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Burst samples one target at a short interval for a bounded time, kept
+// apart from the regular samples so their history is not crowded out.
+type Burst struct {
+	Process  string                `json:"process"`
+	Interval string                `json:"interval"`
+	Started  time.Time             `json:"started"`
+	Until    time.Time             `json:"until"`
+	Running  bool                  `json:"running"`
+	Samples  []Sample              `json:"samples"`
+	Meta     map[string]MetricMeta `json:"meta"`
+}
+
+const (
+	minBurstInterval = 10 * time.Millisecond
+	maxBurstDuration = 5 * time.Minute
+	maxBurstSamples  = 3000
+)
+
+var (
+	burstLock sync.Mutex
+	bursts    = make(map[string]*Burst)
+)
+
+// StartBurst starts sampling processName every interval until duration
+// has passed, replacing the result of a previous burst.
+func StartBurst(processName string, interval time.Duration, duration time.Duration) error {
+	if interval < minBurstInterval {
+		return fmt.Errorf("interval must be at least %s", minBurstInterval)
+	}
+	if duration <= 0 || duration > maxBurstDuration {
+		return fmt.Errorf("duration must be at most %s", maxBurstDuration)
+	}
+	burstLock.Lock()
+	defer burstLock.Unlock()
+	if b := bursts[processName]; b != nil && b.Running {
+		return fmt.Errorf("a burst of %s is already running", processName)
+	}
+	now := time.Now()
+	b := &Burst{
+		Process:  processName,
+		Interval: interval.String(),
+		Started:  now,
+		Until:    now.Add(duration),
+		Running:  true,
+		Samples:  []Sample{},
+	}
+	bursts[processName] = b
+	go runBurst(b, interval)
+	return nil
+}
+
+// burstSource reads stats under the read lock of statsLock, which guards
+// the config the platform reads, so a burst only ever waits for a reload
+// and shares the lock with readers instead of holding up the collector.
+type burstSource struct {
+	procmon.Source
+}
+
+func (s burstSource) ReadStats(pid int) (procmon.PidStats, error) {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	return s.Source.ReadStats(pid)
+}
+
+// runBurst samples b with a collector of its own, so that its counter
+// baselines and per-CPU ticks are apart from the regular collection's.
+func runBurst(b *Burst, interval time.Duration) {
+	c := &procmon.Collector{Source: burstSource{newPlatform()}, Resolve: ResolveTarget, Group: isGroupTarget}
+	t := &procmon.Target{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		// the first sample only sets the counter baselines
+		first := !t.Initialized()
+		s, err := c.CollectTarget(context.Background(), b.Process, t)
+		statsLock.RLock()
+		selected := selectedMetrics
+		statsLock.RUnlock()
+		meta := make(map[string]MetricMeta)
+		for name := range s.Metrics {
+			if selected != nil && !selected[name] {
+				delete(s.Metrics, name)
+				continue
+			}
+			meta[name] = metricMeta(name, interval)
+		}
+
+		burstLock.Lock()
+		if err == nil && !first && len(b.Samples) < maxBurstSamples {
+			b.Samples = append(b.Samples, s)
+			b.Meta = meta
+		}
+		if now.After(b.Until) {
+			b.Running = false
+		}
+		running := b.Running
+		burstLock.Unlock()
+		if !running {
+			return
+		}
+	}
+}
+
+func GetBurst(processName string) *Burst {
+	burstLock.Lock()
+	defer burstLock.Unlock()
+	b := bursts[processName]
+	if b == nil {
+		return nil
+	}
+	result := *b
+	result.Samples = make([]Sample, len(b.Samples))
+	copy(result.Samples, b.Samples)
+	return &result
+}
+
+// burstHandler serves /api/v1/processes/{name}/burst: POST
+// {"interval": "100ms", "duration": "30s"} starts a burst, GET returns
+// the samples of the last one.
+func burstHandler(w http.ResponseWriter, req *http.Request, processName string) {
+	if !HasTarget(processName) {
+		http.Error(w, processName+" is not monitored", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+		b := GetBurst(processName)
+		if b == nil {
+			http.Error(w, "no burst for "+processName, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(b)
+	case http.MethodPost:
+		var body struct {
+			Interval string `json:"interval"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval, err := time.ParseDuration(body.Interval)
+		if err != nil {
+			http.Error(w, "bad interval: "+body.Interval, http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			http.Error(w, "bad duration: "+body.Duration, http.StatusBadRequest)
+			return
+		}
+		if err := StartBurst(processName, interval, duration); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(GetBurst(processName))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup hierarchies are mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// v1 reports "no limit" as the largest page aligned int64
+const cgroupV1Unlimited = int64(1) << 62
+
+// The memory usage and the limits of the cgroup a process is in, e.g. its
+// container or systemd unit. A limit that isn't set is left out.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "cgroup_memory_usage", Files: []string{"cgroup"}, Read: func(pid int) (int64, error) {
+		return cgroupValue(pid, "memory", "memory.current", "memory.usage_in_bytes")
+	}})
+	procmon.Register(procmon.Metric{MetricName: "cgroup_memory_limit", Files: []string{"cgroup"}, Read: func(pid int) (int64, error) {
+		v, err := cgroupValue(pid, "memory", "memory.max", "memory.limit_in_bytes")
+		if err == nil && v >= cgroupV1Unlimited {
+			return 0, procmon.ErrUnavailable
+		}
+		return v, err
+	}})
+	procmon.Register(procmon.Metric{MetricName: "cgroup_cpu_limit", Files: []string{"cgroup"}, Read: cgroupCPULimit})
+}
+
+// pidCgroups maps the controllers of /proc/<pid>/cgroup to their paths; the
+// unified (v2) hierarchy is "".
+func pidCgroups(pid int) (map[string]string, error) {
+	v, err := cached(pid, "cgroup", func() (interface{}, error) {
+		dat, err := pidFile(pid, "cgroup")
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(dat)), "\n") {
+			f := strings.SplitN(line, ":", 3)
+			if len(f) != 3 {
+				continue
+			}
+			for _, controller := range strings.Split(f[1], ",") {
+				m[controller] = f[2]
+			}
+		}
+		return m, nil
+	})
+	m, _ := v.(map[string]string)
+	return m, err
+}
+
+// cgroupFile reads a file of the cgroup of pid, from the v1 hierarchy of
+// controller if there is one and the unified hierarchy otherwise.
+func cgroupFile(pid int, controller string, v2 string, v1 string) (string, error) {
+	cgroups, err := pidCgroups(pid)
+	if err != nil {
+		return "", err
+	}
+	cgroup, ok := cgroups[controller]
+	path := cgroupRoot + "/" + controller + cgroup + "/" + v1
+	if !ok {
+		cgroup = cgroups[""]
+		path = cgroupRoot + cgroup + "/" + v2
+	}
+	if cgroup == "/" || cgroup == "" {
+		// the root cgroup is the whole host
+		return "", procmon.ErrUnavailable
+	}
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		// not mounted, or from another cgroup namespace
+		return "", procmon.ErrUnavailable
+	}
+	return strings.TrimSpace(string(dat)), nil
+}
+
+func cgroupValue(pid int, controller string, v2 string, v1 string) (int64, error) {
+	s, err := cgroupFile(pid, controller, v2, v1)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// "max"
+		return 0, procmon.ErrUnavailable
+	}
+	return v, nil
+}
+
+// cgroupCPULimit is the CFS quota in percent of one core.
+func cgroupCPULimit(pid int) (int64, error) {
+	s, err := cgroupFile(pid, "cpu", "cpu.max", "cpu.cfs_quota_us")
+	if err != nil {
+		return 0, err
+	}
+	f := strings.Fields(s)
+	if len(f) == 2 {
+		// v2 "<quota> <period>"
+		if f[0] == "max" {
+			return 0, procmon.ErrUnavailable
+		}
+		return atoi64(f[0]) * 100 / atoi64(f[1]), nil
+	}
+	quota := atoi64(s)
+	if quota < 0 {
+		return 0, procmon.ErrUnavailable
+	}
+	period, err := cgroupValue(pid, "cpu", "", "cpu.cfs_period_us")
+	if err != nil || period == 0 {
+		return 0, procmon.ErrUnavailable
+	}
+	return quota * 100 / period, nil
+}
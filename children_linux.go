@@ -0,0 +1,39 @@
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+// Direct children that are zombies, i.e. not reaped by the process, or
+// stopped, from the process table scan of the collection cycle. The
+// state in the proc connector table only changes with events, so there it
+// is read again.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "zombie_children", Read: func(pid int) (int64, error) {
+		return countChildren(pid, "Z"), nil
+	}})
+	procmon.Register(procmon.Metric{MetricName: "stopped_children", Read: func(pid int) (int64, error) {
+		return countChildren(pid, "T") + countChildren(pid, "t"), nil
+	}})
+}
+
+func countChildren(pid int, state string) int64 {
+	v, _ := cached(pid, "children", func() (interface{}, error) {
+		states := make(map[string]int64)
+		s := currentScan()
+		var buf []byte
+		var f procFields
+		for _, e := range s.entries {
+			if e.ppid != pid {
+				continue
+			}
+			if s.live {
+				var ok bool
+				if e, ok = readProcEntry(e.pid, &buf, &f); !ok || e.ppid != pid {
+					continue
+				}
+			}
+			states[e.state]++
+		}
+		return states, nil
+	})
+	return v.(map[string]int64)[state]
+}
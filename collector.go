@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxSamples is the number of samples kept per target, see Config.Retention.
+var maxSamples = 300
+
+// Sample is the collector's sample, see pkg/procmon.
+type Sample = procmon.Sample
+
+// ProcessStats is the collection state and history of a target.
+type ProcessStats struct {
+	procmon.Target
+	// fastUntil is when adaptive sampling of the target slows down again
+	fastUntil time.Time
+}
+
+var (
+	statsLock sync.RWMutex
+	statsMap  = make(map[string]*ProcessStats)
+)
+
+func atoi64(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collector turns the platform's raw stats into samples.
+var collector = &procmon.Collector{
+	Source:  platform,
+	Resolve: ResolveTarget,
+	Group:   isGroupTarget,
+}
+
+// collectOnce collects a target, from a scan of its own, and drops the
+// metrics not selected. Callers hold statsLock.
+func collectOnce(processName string, ps *ProcessStats) (Sample, bool) {
+	beginCycleScan()
+	defer endCycleScan()
+	s, err := collector.CollectTarget(context.Background(), processName, &ps.Target)
+	if err != nil {
+		return Sample{}, false
+	}
+	return selectMetrics(s), true
+}
+
+// selectMetrics adds the metrics of s to knownMetrics and drops those not
+// selected. Callers hold statsLock.
+func selectMetrics(s Sample) Sample {
+	for name := range s.Metrics {
+		knownMetrics[name] = true
+	}
+	if selectedMetrics != nil {
+		for name := range s.Metrics {
+			if !selectedMetrics[name] {
+				delete(s.Metrics, name)
+			}
+		}
+	}
+	return s
+}
+
+// selectedMetrics limits the metrics kept in samples, nil keeps them all.
+// knownMetrics is every metric the collector has produced so far.
+var (
+	selectedMetrics map[string]bool
+	knownMetrics    = make(map[string]bool)
+)
+
+var (
+	collectInterval = time.Second
+	intervalChanged = make(chan time.Duration, 1)
+)
+
+// collectWorkers is the number of targets collected concurrently, and
+// collectTimeout how long a cycle may take, the tick interval if zero;
+// targets not collected by then are skipped for the cycle. See
+// Config.Workers and Config.CollectTimeout.
+var (
+	collectWorkers = 8
+	collectTimeout time.Duration
+)
+
+var errCycleDeadline = errors.New("collection cycle deadline exceeded")
+
+type targetResult struct {
+	name     string
+	ps       *ProcessStats
+	oldPids  []int
+	oldStart time.Time
+	oldError string
+	// prev is the previous sample, which the deltas are relative to
+	prev Sample
+	s    Sample
+	err  error
+}
+
+// collectTargets collects the targets due reports true for, every one if
+// due is nil, with collectWorkers goroutines. Targets only touch their own
+// ProcessStats and the per-pid reads are safe for concurrent use, see
+// pidcache.go. Callers hold statsLock.
+func collectTargets(due func(ps *ProcessStats) bool) []*targetResult {
+	timeout := collectTimeout
+	if timeout == 0 {
+		timeout = tickInterval()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var results []*targetResult
+	for name, ps := range statsMap {
+		if due != nil && !due(ps) {
+			continue
+		}
+		r := &targetResult{name: name, ps: ps, oldPids: ps.Pids, oldStart: ps.StartTime, oldError: ps.LastError}
+		r.prev, _ = ps.Samples.Last()
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].name < results[j].name })
+	jobs := make(chan *targetResult)
+	var wg sync.WaitGroup
+	for i := 0; i < collectWorkers && i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if ctx.Err() != nil {
+					r.err = errCycleDeadline
+					continue
+				}
+				r.s, r.err = collector.CollectTarget(ctx, r.name, &r.ps.Target)
+				if ctx.Err() != nil && r.err == ctx.Err() {
+					r.err = errCycleDeadline
+				}
+			}
+		}()
+	}
+	for _, r := range results {
+		jobs <- r
+	}
+	close(jobs)
+	wg.Wait()
+	for _, r := range results {
+		if r.err == errCycleDeadline {
+			// as if not collected, so no lifecycle event is missed
+			r.ps.Pids = r.oldPids
+			r.ps.LastError = r.err.Error()
+		}
+	}
+	return results
+}
+
+// ringSize is the number of samples the history of a target holds. With
+// adaptive sampling it has room for the retention at the fast rate, so a
+// burst doesn't push out the history around it, and samples older than the
+// retention are dropped instead. Callers hold statsLock.
+func ringSize() int {
+	if adaptive == nil {
+		return maxSamples
+	}
+	return maxSamples * int((collectInterval+adaptive.fast-1)/adaptive.fast)
+}
+
+// setSchedule changes the collection interval and adaptive sampling from
+// the next tick on. Callers hold statsLock.
+func setSchedule(d time.Duration, a *adaptiveSampling) {
+	old := tickInterval()
+	collectInterval, adaptive = d, a
+	if tickInterval() == old {
+		return
+	}
+	select {
+	case <-intervalChanged:
+	default:
+	}
+	intervalChanged <- tickInterval()
+}
+
+// setTargets makes statsMap hold exactly names, keeping the history of the
+// targets that stay. Callers hold statsLock.
+func setTargets(names []string) {
+	keep := make(map[string]bool)
+	for _, name := range names {
+		keep[name] = true
+		if statsMap[name] == nil {
+			statsMap[name] = &ProcessStats{}
+		}
+	}
+	for name := range statsMap {
+		if !keep[name] {
+			delete(statsMap, name)
+		}
+	}
+}
+
+// AddTarget starts monitoring name from the next collection, returning
+// false if it is already monitored.
+func AddTarget(name string) bool {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	if statsMap[name] != nil {
+		return false
+	}
+	statsMap[name] = &ProcessStats{}
+	return true
+}
+
+// RemoveTarget stops monitoring name and drops its history, returning false
+// if it was not monitored.
+func RemoveTarget(name string) bool {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	if statsMap[name] == nil {
+		return false
+	}
+	delete(statsMap, name)
+	return true
+}
+
+func TargetNames() []string {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	names := make([]string, 0, len(statsMap))
+	for name := range statsMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func HasTarget(name string) bool {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	return statsMap[name] != nil
+}
+
+func anyDue(due func(ps *ProcessStats) bool) bool {
+	for _, ps := range statsMap {
+		if due(ps) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSampleBytes estimates the memory held by the sample history of every
+// target.
+func GetSampleBytes() int64 {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	var total int64
+	for _, ps := range statsMap {
+		total += ps.Samples.Bytes()
+	}
+	return total
+}
+
+// recordCollectionError adds an "error" event when a target that has
+// processes fails with a new error, not on every cycle it keeps failing.
+func recordCollectionError(r *targetResult) {
+	if len(r.ps.Pids) == 0 || r.err.Error() == r.oldError {
+		return
+	}
+	recordEvent(LifecycleEvent{
+		Event:   "error",
+		Process: r.name,
+		Pid:     firstPid(r.ps.Pids),
+		Time:    time.Now(),
+		Host:    hostname,
+		Error:   r.err.Error(),
+	})
+}
+
+func collectAll() map[string]Sample {
+	return collectDue(nil)
+}
+
+// collectDue collects the targets due reports true for, see collectTargets,
+// and returns their samples.
+func collectDue(due func(ps *ProcessStats) bool) map[string]Sample {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	cycle := make(map[string]Sample)
+	if due != nil && !anyDue(due) {
+		return cycle
+	}
+	beginCycleScan()
+	defer endCycleScan()
+	var batch []byte
+	for _, r := range collectTargets(due) {
+		name, ps := r.name, r.ps
+		if r.err == errCycleDeadline {
+			fmt.Println(name+":", r.err)
+			recordCollectionError(r)
+			continue
+		}
+		oldPid, oldStart := firstPid(r.oldPids), r.oldStart
+		if event := lifecycleEvent(name, oldPid, oldStart, ps); event != "" {
+			if event != "disappear" {
+				resetAnomalies(name)
+			}
+			e := LifecycleEvent{
+				Event:   event,
+				Process: name,
+				Pid:     firstPid(ps.Pids),
+				OldPid:  oldPid,
+				Time:    ps.LastScrape,
+				Host:    hostname,
+			}
+			recordEvent(e)
+			runHooks(e)
+		}
+		if r.err != nil {
+			recordCollectionError(r)
+			continue
+		}
+		if r.prev.Time != 0 {
+			normalizeDeltas(r.s, time.Duration(r.s.Time-r.prev.Time)*time.Millisecond, collectInterval)
+		}
+		s := selectMetrics(r.s)
+		// a no-op unless the retention was changed by a reload
+		ps.Samples.Resize(ringSize())
+		ps.Samples.Push(s)
+		if adaptive != nil {
+			ps.Samples.DropBefore(s.Time - int64(time.Duration(maxSamples)*collectInterval/time.Millisecond))
+		}
+		fmt.Println(name, "pid:", s.Pid, "rss:", s.Metrics["rss"], "vsize:", s.Metrics["vsize"], "cpu last sec", s.Metrics["cpu"])
+		updateAdaptive(name, ps, r.prev, s)
+		evaluateAlerts(name, s)
+		detectAnomalies(name, s)
+		if len(influxSinks) > 0 {
+			batch = append(batch, lineProtocol(name, s)...)
+		}
+		cycle[name] = s
+		addToSummary(name, s)
+	}
+	queuePush(batch)
+	queueAgentCycle(cycle)
+	recordCycle(cycle)
+	return cycle
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Processes      []ProcessConfig     `json:"processes"`
+	Groups         map[string][]string `json:"groups,omitempty"`
+	Interval       string              `json:"interval,omitempty"`
+	Retention      string              `json:"retention,omitempty"`
+	Workers        int                 `json:"workers,omitempty"`
+	CollectTimeout string              `json:"collect_timeout,omitempty"`
+	Adaptive       *AdaptiveConfig     `json:"adaptive,omitempty"`
+	TimeFormat     string              `json:"time_format,omitempty"`
+	Metrics        []string            `json:"metrics,omitempty"`
+	PerCPU         bool                `json:"per_cpu,omitempty"`
+	RawCounters    bool                `json:"raw_counters,omitempty"`
+	FdTypes        bool                `json:"fd_types,omitempty"`
+	Sockets        bool                `json:"sockets,omitempty"`
+	Taskstats      bool                `json:"taskstats,omitempty"`
+	IODetail       bool                `json:"io_detail,omitempty"`
+	Smaps          bool                `json:"smaps,omitempty"`
+	NUMA           bool                `json:"numa,omitempty"`
+	PerPid         bool                `json:"per_pid,omitempty"`
+	Snapshot       bool                `json:"snapshot,omitempty"`
+	Alerts         []string            `json:"alerts"`
+	Webhooks       []WebhookNotifier   `json:"webhooks"`
+	Slack          []SlackNotifier     `json:"slack"`
+	Email          []EmailNotifier     `json:"email"`
+	Anomaly        *AnomalyConfig      `json:"anomaly"`
+	Influx         []InfluxSink        `json:"influx"`
+	Hooks          []Hook              `json:"hooks"`
+	Signals        []SignalAction      `json:"signals"`
+	Peers          []PeerConfig        `json:"peers,omitempty"`
+	Pushgateway    *PushgatewayConfig  `json:"pushgateway,omitempty"`
+}
+
+var (
+	// configFile is the -config path re-read on reload, defaultTargets
+	// the -name targets used when the config lists no processes.
+	configFile     string
+	defaultTargets []string
+	// currentConfig is the last config applied.
+	currentConfig Config
+)
+
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+	dat, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(dat, &cfg)
+	return cfg, err
+}
+
+// ApplyConfig validates cfg and then switches the collector, alert rules
+// and notifiers over to it in one step. Targets that stay configured keep
+// their sample history, and unchanged alert rules keep their state.
+func ApplyConfig(cfg Config) error {
+	var targets []string
+	for _, p := range cfg.Processes {
+		if err := checkLabels(p); err != nil {
+			return err
+		}
+		targets = append(targets, p.Match)
+	}
+	if err := checkGroups(cfg.Groups); err != nil {
+		return err
+	}
+	if len(targets) == 0 && len(cfg.Groups) == 0 {
+		targets = defaultTargets
+	}
+	targets = append(targets, groupTargets(cfg.Groups)...)
+	interval := time.Second
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("bad interval %q", cfg.Interval)
+		}
+		if d < minInterval {
+			return fmt.Errorf("interval %q is shorter than %v", cfg.Interval, minInterval)
+		}
+		interval = d
+	}
+	workers := 8
+	if cfg.Workers < 0 {
+		return fmt.Errorf("bad workers %d", cfg.Workers)
+	} else if cfg.Workers > 0 {
+		workers = cfg.Workers
+	}
+	var timeout time.Duration
+	if cfg.CollectTimeout != "" {
+		d, err := time.ParseDuration(cfg.CollectTimeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("bad collect_timeout %q", cfg.CollectTimeout)
+		}
+		timeout = d
+	}
+	adaptiveSampling, err := parseAdaptive(cfg.Adaptive, interval)
+	if err != nil {
+		return err
+	}
+	format := "unix_ms"
+	if cfg.TimeFormat != "" {
+		if err := checkTimeFormat(cfg.TimeFormat); err != nil {
+			return err
+		}
+		format = cfg.TimeFormat
+	}
+	samples := 300
+	if cfg.Retention != "" {
+		d, err := time.ParseDuration(cfg.Retention)
+		if err != nil || d < interval {
+			return fmt.Errorf("bad retention %q", cfg.Retention)
+		}
+		samples = int(d / interval)
+	}
+	var metrics map[string]bool
+	if len(cfg.Metrics) > 0 {
+		metrics = make(map[string]bool)
+		for _, m := range cfg.Metrics {
+			metrics[m] = true
+		}
+	}
+	var rules []*AlertRule
+	for _, text := range cfg.Alerts {
+		r, err := ParseAlertRule(text)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	var ns []Notifier
+	for _, w := range cfg.Webhooks {
+		ns = append(ns, w)
+	}
+	for _, s := range cfg.Slack {
+		ns = append(ns, s)
+	}
+	for _, e := range cfg.Email {
+		ns = append(ns, e)
+	}
+	for _, n := range ns {
+		if err := CheckTemplates(n); err != nil {
+			return err
+		}
+	}
+	if cfg.Anomaly != nil {
+		cfg.Anomaly.setDefaults()
+	}
+	for _, h := range cfg.Hooks {
+		if err := checkHook(h); err != nil {
+			return err
+		}
+	}
+	for _, a := range cfg.Signals {
+		if err := checkSignal(a.Signal); err != nil {
+			return err
+		}
+	}
+	if cfg.Pushgateway != nil {
+		if err := cfg.Pushgateway.setDefaults(); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Peers {
+		if err := checkPeer(&cfg.Peers[i]); err != nil {
+			return err
+		}
+	}
+	for _, sink := range cfg.Influx {
+		if sink.URL == "" {
+			return errors.New("influx sink without url")
+		}
+	}
+
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	setGroups(cfg.Groups)
+	setTargets(targets)
+	setTargetLabels(cfg.Processes)
+	selectedMetrics = metrics
+	perCPUEnabled = cfg.PerCPU
+	rawCountersEnabled = cfg.RawCounters
+	fdTypesEnabled = cfg.FdTypes
+	socketsEnabled = cfg.Sockets
+	taskstatsEnabled = cfg.Taskstats
+	ioDetailEnabled = cfg.IODetail
+	smapsEnabled = cfg.Smaps
+	numaEnabled = cfg.NUMA
+	collector.PerPid = cfg.PerPid
+	snapshotReads = cfg.Snapshot
+	for _, r := range rules {
+		for _, old := range alertRules {
+			if old.Text == r.Text {
+				r.pendingSince, r.firing = old.pendingSince, old.firing
+			}
+		}
+	}
+	alertRules = rules
+	notifiers = ns
+	anomalyConfig = cfg.Anomaly
+	influxSinks = cfg.Influx
+	peers = cfg.Peers
+	pushgateway = cfg.Pushgateway
+	hooks = cfg.Hooks
+	signalActions = cfg.Signals
+	maxSamples = samples
+	collectWorkers = workers
+	collectTimeout = timeout
+	timeFormat = format
+	fastPath = interval < time.Second
+	setSchedule(interval, adaptiveSampling)
+	currentConfig = cfg
+	return nil
+}
+
+// GetConfig returns the config in effect, including targets and metrics
+// changed through the API since it was applied.
+func GetConfig() Config {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	cfg := currentConfig
+	cfg.Processes = make([]ProcessConfig, 0, len(statsMap))
+	for name := range statsMap {
+		if strings.HasPrefix(name, "group:") {
+			// from cfg.Groups
+			continue
+		}
+		cfg.Processes = append(cfg.Processes, ProcessConfig{Match: name, Labels: TargetLabels(name)})
+	}
+	sort.Slice(cfg.Processes, func(i, j int) bool { return cfg.Processes[i].Match < cfg.Processes[j].Match })
+	cfg.Metrics = sortedKeys(selectedMetrics)
+	cfg.Interval = collectInterval.String()
+	return cfg
+}
+
+// redacted stands in for secrets in the config served by GET
+// /api/v1/config. A PUT sending it back keeps the value in effect for that
+// field, so a config can be fetched, edited and put back.
+const redacted = "<redacted>"
+
+// secrets returns the fields of cfg that hold credentials, keyed by their
+// place in the config. Slack and webhook URLs are credentials themselves,
+// other URLs only when they carry a user or password.
+func (cfg *Config) secrets() map[string]*string {
+	m := make(map[string]*string)
+	for i := range cfg.Webhooks {
+		m[fmt.Sprintf("webhooks[%d].url", i)] = &cfg.Webhooks[i].URL
+	}
+	for i := range cfg.Slack {
+		m[fmt.Sprintf("slack[%d].url", i)] = &cfg.Slack[i].URL
+	}
+	for i := range cfg.Email {
+		m[fmt.Sprintf("email[%d].password", i)] = &cfg.Email[i].Password
+	}
+	for i := range cfg.Hooks {
+		m[fmt.Sprintf("hooks[%d].webhook", i)] = &cfg.Hooks[i].Webhook
+	}
+	urls := make(map[string]*string)
+	for i := range cfg.Influx {
+		urls[fmt.Sprintf("influx[%d].url", i)] = &cfg.Influx[i].URL
+	}
+	for i := range cfg.Peers {
+		urls[fmt.Sprintf("peers[%d].url", i)] = &cfg.Peers[i].URL
+	}
+	if cfg.Pushgateway != nil {
+		urls["pushgateway.url"] = &cfg.Pushgateway.URL
+	}
+	for key, p := range urls {
+		if *p == redacted || hasCredentials(*p) {
+			m[key] = p
+		}
+	}
+	return m
+}
+
+func hasCredentials(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	q := u.Query()
+	return u.User != nil || q.Get("p") != "" || q.Get("password") != "" || q.Get("token") != ""
+}
+
+// redactConfig returns cfg with its secrets replaced by redacted, leaving
+// the config in effect untouched.
+func redactConfig(cfg Config) Config {
+	cfg.Webhooks = append([]WebhookNotifier(nil), cfg.Webhooks...)
+	cfg.Slack = append([]SlackNotifier(nil), cfg.Slack...)
+	cfg.Email = append([]EmailNotifier(nil), cfg.Email...)
+	cfg.Hooks = append([]Hook(nil), cfg.Hooks...)
+	cfg.Influx = append([]InfluxSink(nil), cfg.Influx...)
+	cfg.Peers = append([]PeerConfig(nil), cfg.Peers...)
+	if cfg.Pushgateway != nil {
+		pg := *cfg.Pushgateway
+		cfg.Pushgateway = &pg
+	}
+	for _, p := range cfg.secrets() {
+		if *p != "" {
+			*p = redacted
+		}
+	}
+	return cfg
+}
+
+// unredactConfig puts the secrets of old back into the fields of cfg that
+// were sent as redacted. Entries are matched by position, so reordering a
+// list needs its secrets sent again.
+func unredactConfig(cfg *Config, old Config) error {
+	secrets := old.secrets()
+	for key, p := range cfg.secrets() {
+		if *p != redacted {
+			continue
+		}
+		v, ok := secrets[key]
+		if !ok || *v == "" {
+			return fmt.Errorf("%s is %s but has no current value", key, redacted)
+		}
+		*p = *v
+	}
+	return nil
+}
+
+// configHandler serves /api/v1/config: GET returns the effective config
+// with secrets redacted, PUT replaces it as a whole, leaving the current one
+// in place if the new one is invalid. As the config can run commands it needs auth configured.
+func configHandler(w http.ResponseWriter, req *http.Request) {
+	if !authConfigured() {
+		http.Error(w, "/api/v1/config requires -auth-user, -auth-htpasswd or -auth-token-file", http.StatusForbidden)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var cfg Config
+		dec := json.NewDecoder(req.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		statsLock.RLock()
+		err := unredactConfig(&cfg, currentConfig)
+		statsLock.RUnlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ApplyConfig(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Println("configuration replaced through the API")
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(redactConfig(GetConfig()))
+}
+
+func ReloadConfig() error {
+	if configFile == "" {
+		return errors.New("no -config file to reload")
+	}
+	if !replayEnd.IsZero() {
+		return errors.New("cannot reload while replaying a recording")
+	}
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	return ApplyConfig(cfg)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The conformance subcommand runs the collector against a synthetic proc
+// tree covering the edge cases real kernels and workloads produce, so
+// packagers can check a build without a particular set of processes.
+
+var errSkip = errors.New("skip")
+
+type conformanceCase struct {
+	name string
+	run  func(f *procFixture) error
+}
+
+type procFixture struct {
+	root string
+}
+
+func (f *procFixture) write(path string, content string) {
+	full := filepath.Join(f.root, path)
+	check(os.MkdirAll(filepath.Dir(full), 0755))
+	check(ioutil.WriteFile(full, []byte(content), 0644))
+}
+
+// addProcess creates /<pid>/stat, statm, status, cgroup, limits, oom_score,
+// oom_score_adj, schedstat of the main thread, fd and fdinfo for a fake
+// process in process group pgid.
+func (f *procFixture) addProcess(pid int, comm string, pgid int, utime int64) {
+	fields := make([]string, 52)
+	for i := range fields {
+		fields[i] = "0"
+	}
+	fields[0] = strconv.Itoa(pid)
+	fields[1] = "(" + comm + ")"
+	fields[2] = "S"
+	fields[3] = "1"
+	fields[4] = strconv.Itoa(pgid)
+	fields[5] = strconv.Itoa(pgid)
+	fields[13] = strconv.FormatInt(utime, 10)
+	fields[14] = "5"
+	fields[19] = "3"
+	fields[21] = "100"
+	dir := strconv.Itoa(pid)
+	f.write(dir+"/stat", strings.Join(fields, " ")+"\n")
+	f.write(dir+"/statm", "1000 200 50 10 0 300 0\n")
+	f.write(dir+"/status", "Name:\t"+comm+"\nPid:\t"+dir+"\nThreads:\t3\n"+
+		"voluntary_ctxt_switches:\t10\nnonvoluntary_ctxt_switches:\t2\n")
+	f.write(dir+"/cgroup", "0::/\n")
+	f.write(dir+"/limits", "Limit                     Soft Limit           Hard Limit           Units     \n"+
+		"Max open files            1024                 4096                 files     \n")
+	f.write(dir+"/oom_score", "0\n")
+	f.write(dir+"/oom_score_adj", "0\n")
+	f.write(dir+"/task/"+dir+"/schedstat", "1000 500 3\n")
+	check(os.MkdirAll(filepath.Join(f.root, dir, "fd"), 0755))
+	check(os.MkdirAll(filepath.Join(f.root, dir, "fdinfo"), 0755))
+}
+
+// setStartTime changes the starttime field of a fake process, as if its pid
+// had been reused.
+func (f *procFixture) setStartTime(pid int, starttime int64) {
+	path := filepath.Join(f.root, strconv.Itoa(pid), "stat")
+	dat, err := ioutil.ReadFile(path)
+	check(err)
+	fields := strings.Fields(string(dat))
+	fields[21] = strconv.FormatInt(starttime, 10)
+	check(ioutil.WriteFile(path, []byte(strings.Join(fields, " ")+"\n"), 0644))
+}
+
+func expect(name string, got int64, want int64) error {
+	if got != want {
+		return fmt.Errorf("%s = %d, want %d", name, got, want)
+	}
+	return nil
+}
+
+var conformanceCases = []conformanceCase{
+	{"plain process", func(f *procFixture) error {
+		f.addProcess(100, "worker", 100, 10)
+		st, err := platform.ReadStats(100)
+		if err != nil {
+			return err
+		}
+		if err := expect("cpu", st.Counters["cpu"], 15); err != nil {
+			return err
+		}
+		if err := expect("rss", st.Gauges["rss"], 200*pageSize); err != nil {
+			return err
+		}
+		return expect("threads", st.Gauges["threads"], 3)
+	}},
+	{"comm with spaces and parentheses", func(f *procFixture) error {
+		f.addProcess(101, "my (weird) proc) x", 101, 40)
+		st, err := platform.ReadStats(101)
+		if err != nil {
+			return err
+		}
+		if err := expect("cpu", st.Counters["cpu"], 45); err != nil {
+			return err
+		}
+		return expect("threads", st.Gauges["threads"], 3)
+	}},
+	{"truncated stat", func(f *procFixture) error {
+		f.addProcess(102, "worker", 102, 10)
+		f.write("102/stat", "102 (work")
+		if _, err := platform.ReadStats(102); err == nil {
+			return errors.New("expected an error")
+		}
+		return nil
+	}},
+	{"missing statm", func(f *procFixture) error {
+		f.addProcess(103, "worker", 103, 10)
+		check(os.Remove(filepath.Join(f.root, "103/statm")))
+		ps := &ProcessStats{}
+		if _, ok := collectOnce("pgid:103", ps); ok {
+			return errors.New("expected no sample")
+		}
+		if ps.LastError == "" {
+			return errors.New("expected LastError to be set")
+		}
+		return nil
+	}},
+	{"unreadable fd directory", func(f *procFixture) error {
+		f.addProcess(104, "worker", 104, 10)
+		fdDir := filepath.Join(f.root, "104/fd")
+		check(os.Chmod(fdDir, 0))
+		defer os.Chmod(fdDir, 0755)
+		if _, err := ioutil.ReadDir(fdDir); err == nil {
+			// root bypasses permission checks
+			return errSkip
+		}
+		st, err := platform.ReadStats(104)
+		if err != nil {
+			return err
+		}
+		if _, ok := st.Gauges["inotify_watches"]; ok {
+			return errors.New("inotify_watches reported without access to fd")
+		}
+		return nil
+	}},
+	{"counter reset", func(f *procFixture) error {
+		f.addProcess(105, "worker", 105, 1000)
+		ps := &ProcessStats{}
+		collectOnce("pgid:105", ps)
+		f.addProcess(105, "worker", 105, 10)
+		s, ok := collectOnce("pgid:105", ps)
+		if !ok {
+			return errors.New(ps.LastError)
+		}
+		if s.Metrics["cpu"] < 0 {
+			return fmt.Errorf("cpu delta %d after counter reset", s.Metrics["cpu"])
+		}
+		return nil
+	}},
+	{"pid reused", func(f *procFixture) error {
+		f.addProcess(110, "worker", 110, 100)
+		ps := &ProcessStats{}
+		collectOnce("pgid:110", ps)
+		f.addProcess(110, "worker", 110, 500)
+		f.setStartTime(110, 200)
+		s, ok := collectOnce("pgid:110", ps)
+		if !ok {
+			return errors.New(ps.LastError)
+		}
+		return expect("cpu", s.Metrics["cpu"], 0)
+	}},
+	{"process exits", func(f *procFixture) error {
+		f.addProcess(106, "worker", 106, 10)
+		ps := &ProcessStats{}
+		if _, ok := collectOnce("pgid:106", ps); !ok {
+			return errors.New(ps.LastError)
+		}
+		check(os.RemoveAll(filepath.Join(f.root, "106")))
+		if _, ok := collectOnce("pgid:106", ps); ok {
+			return errors.New("expected no sample")
+		}
+		return nil
+	}},
+	{"group member exits", func(f *procFixture) error {
+		f.addProcess(107, "worker", 107, 100)
+		f.addProcess(108, "helper", 107, 100)
+		ps := &ProcessStats{}
+		collectOnce("pgid:107", ps)
+		check(os.RemoveAll(filepath.Join(f.root, "108")))
+		f.addProcess(107, "worker", 107, 120)
+		s, ok := collectOnce("pgid:107", ps)
+		if !ok {
+			return errors.New(ps.LastError)
+		}
+		return expect("cpu", s.Metrics["cpu"], 20)
+	}},
+	{"inotify fdinfo", func(f *procFixture) error {
+		f.addProcess(109, "watcher", 109, 10)
+		check(os.Symlink("anon_inode:inotify", filepath.Join(f.root, "109/fd/3")))
+		f.write("109/fdinfo/3", "pos:\t0\nflags:\t00\nmnt_id:\t15\n"+
+			"inotify wd:1 ino:2 sdev:3 mask:fce ignored_mask:0\n"+
+			"inotify wd:2 ino:4 sdev:3 mask:fce ignored_mask:0\n")
+		st, err := platform.ReadStats(109)
+		if err != nil {
+			return err
+		}
+		if err := expect("inotify_instances", st.Gauges["inotify_instances"], 1); err != nil {
+			return err
+		}
+		return expect("inotify_watches", st.Gauges["inotify_watches"], 2)
+	}},
+}
+
+func runConformanceCase(c conformanceCase, f *procFixture) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.run(f)
+}
+
+// RunConformance runs every case and returns the process exit code.
+func RunConformance(args []string) int {
+	dir, err := ioutil.TempDir("", "procmon-conformance")
+	check(err)
+	defer os.RemoveAll(dir)
+	f := &procFixture{root: dir}
+	f.write("stat", "cpu  0 0 0 0 0 0 0 0 0 0\nbtime 1700000000\n")
+	f.write("uptime", "1000.00 4000.00\n")
+
+	saved := procRoot
+	procRoot = dir
+	defer func() { procRoot = saved }()
+
+	passed, failed, skipped := 0, 0, 0
+	for _, c := range conformanceCases {
+		err := runConformanceCase(c, f)
+		switch err {
+		case nil:
+			passed++
+			fmt.Println("PASS ", c.name)
+		case errSkip:
+			skipped++
+			fmt.Println("SKIP ", c.name)
+		default:
+			failed++
+			fmt.Println("FAIL ", c.name+":", err)
+		}
+	}
+	fmt.Printf("%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
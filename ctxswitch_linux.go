@@ -0,0 +1,23 @@
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+// Context switches from status. Involuntary ones mean the process wanted
+// to keep running but was preempted, i.e. CPU contention; ctx_switch is
+// the sum of both.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "ctx_switch_voluntary", Files: []string{"status"}, Counter: true, Read: func(pid int) (int64, error) {
+		return statusValue(pid, statusVoluntaryCtxt)
+	}})
+	procmon.Register(procmon.Metric{MetricName: "ctx_switch_involuntary", Files: []string{"status"}, Counter: true, Read: func(pid int) (int64, error) {
+		return statusValue(pid, statusNonvoluntaryCtxt)
+	}})
+	procmon.Register(procmon.Metric{MetricName: "ctx_switch", Files: []string{"status"}, Counter: true, Read: func(pid int) (int64, error) {
+		v, err := statusValue(pid, statusVoluntaryCtxt)
+		if err != nil {
+			return 0, err
+		}
+		nv, err := statusValue(pid, statusNonvoluntaryCtxt)
+		return v + nv, err
+	}})
+}
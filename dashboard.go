@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+func GetMetrics() map[string][]Sample {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	result := make(map[string][]Sample)
+	for name, ps := range statsMap {
+		result[name] = ps.Samples.Slice()
+	}
+	return result
+}
+
+// metrics serves the retained samples of every target, with ?since= (unix
+// ms) only those taken after, with ?process= and ?metric= (comma separated)
+// only those targets and metrics, and ?time_format=rfc3339 with RFC 3339
+// times.
+func metrics(w http.ResponseWriter, req *http.Request) {
+	format, err := requestTimeFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := GetMetrics()
+	q := req.URL.Query()
+	if processes := q.Get("process"); processes != "" {
+		keep := make(map[string]bool)
+		for _, name := range strings.Split(processes, ",") {
+			keep[name] = true
+		}
+		for name := range result {
+			if !keep[name] {
+				delete(result, name)
+			}
+		}
+	}
+	if since := q.Get("since"); since != "" {
+		t := atoi64(since)
+		for name, samples := range result {
+			i := sort.Search(len(samples), func(i int) bool { return samples[i].Time > t })
+			result[name] = samples[i:]
+		}
+	}
+	if metricList := q.Get("metric"); metricList != "" {
+		names := strings.Split(metricList, ",")
+		for _, samples := range result {
+			for i, s := range samples {
+				// the metric maps are shared with the history
+				m := make(map[string]int64, len(names))
+				for _, name := range names {
+					if v, ok := s.Metrics[name]; ok {
+						m[name] = v
+					}
+				}
+				samples[i].Metrics = m
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if format == "rfc3339" {
+		converted := make(map[string][]sampleRFC3339, len(result))
+		for name, samples := range result {
+			converted[name] = samplesRFC3339(samples)
+		}
+		json.NewEncoder(w).Encode(converted)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func mainPage(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>linux-proc-exporter</title>
+<script src="https://cdn.jsdelivr.net/npm/chart.js@4"></script>
+<style>
+body { font-family: sans-serif; }
+.chart { width: 800px; height: 250px; margin-bottom: 30px; }
+.export { text-align: right; font-size: small; }
+.state { padding: 1px 8px; border-radius: 8px; color: #fff; }
+#states span.process { margin-right: 4px; }
+#warnings { background: #fff3cd; border: 1px solid #e0c060; padding: 4px 8px; width: 784px; }
+#states span.state { margin-right: 16px; }
+#feed { width: 800px; max-height: 120px; overflow-y: auto; border: 1px solid #ccc; font-size: small; }
+#feed div { padding: 1px 4px; }
+#feed .event { display: inline-block; width: 70px; }
+#scrubber-box { position: sticky; bottom: 0; background: #fff; padding: 4px 0; }
+#scrubber { width: 800px; height: 50px; border: 1px solid #ccc; cursor: grab; }
+</style>
+</head>
+<body>
+<h1>linux-proc-exporter</h1>
+<p><a href="/targets">targets</a> | <a href="/inventory">inventory</a> | <a href="/map">map</a></p>
+<p>
+<button id="pause">Pause</button>
+<select id="range">
+<option value="5m">5m</option>
+<option value="15m">15m</option>
+<option value="1h">1h</option>
+<option value="custom">custom</option>
+</select>
+<input id="custom-range" size="6" placeholder="e.g. 30m" style="display: none">
+<label><input type="checkbox" id="lowbw"> low bandwidth</label>
+<select id="mode">
+<option value="live">live</option>
+<option value="history">fixed 1 minute window</option>
+</select>
+</p>
+<p id="warnings" style="display: none"></p>
+<p id="states"></p>
+<div id="feed"></div>
+<div id="charts"></div>
+<div id="scrubber-box">
+<canvas id="scrubber" width="800" height="50"></canvas>
+<div id="scrubber-label"></div>
+</div>
+<script>
+const colors = ["#1f77b4", "#ff7f0e", "#2ca02c", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"];
+const charts = {};
+// The charts show a viewport of view.width ms ending at view.end, or at the
+// newest sample when view.end is null. The scrubber shows everything kept.
+const historyWidth = 60000;
+const view = {width: 300000, end: null};
+let retention = {min: 0, max: 0};
+let last = null;
+let paused = false;
+// Low bandwidth mode polls less often for fewer, delta encoded points and
+// turns tooltips off.
+const lowbw = document.getElementById("lowbw");
+lowbw.checked = localStorage.getItem("lowbw") === "1";
+// units of each metric from /api/v1/metrics
+let meta = {};
+
+` + formatValueJS + `
+// lifecycle events from /api/v1/events, drawn as vertical lines
+let events = [];
+const eventColors = {appear: "#2ca02c", disappear: "#7f7f7f", restart: "#d62728", error: "#ff7f0e"};
+const eventsPlugin = {
+	id: "events",
+	afterDatasetsDraw(chart) {
+		const area = chart.chartArea;
+		const ctx = chart.ctx;
+		ctx.save();
+		ctx.font = "10px sans-serif";
+		events.forEach(e => {
+			const x = chart.scales.x.getPixelForValue(Date.parse(e.time));
+			if (x < area.left || x > area.right) {
+				return;
+			}
+			ctx.strokeStyle = ctx.fillStyle = eventColors[e.event] || "#000";
+			ctx.setLineDash([4, 3]);
+			ctx.beginPath();
+			ctx.moveTo(x, area.top);
+			ctx.lineTo(x, area.bottom);
+			ctx.stroke();
+			ctx.fillText(e.process + " " + e.event, x + 3, area.top + 10);
+		});
+		ctx.restore();
+	}
+};
+
+function chartFor(metric) {
+	if (charts[metric]) {
+		return charts[metric];
+	}
+	const div = document.createElement("div");
+	div.className = "chart";
+	const canvas = document.createElement("canvas");
+	div.appendChild(canvas);
+	const exportDiv = document.createElement("div");
+	exportDiv.className = "export";
+	exportDiv.append("export ");
+	["csv", "json"].forEach(format => {
+		const button = document.createElement("button");
+		button.textContent = format.toUpperCase();
+		button.addEventListener("click", () => exportChart(metric, format));
+		exportDiv.append(button, " ");
+	});
+	div.appendChild(exportDiv);
+	document.getElementById("charts").appendChild(div);
+	charts[metric] = new Chart(canvas, {
+		type: "line",
+		data: {datasets: []},
+		plugins: [eventsPlugin],
+		options: {
+			animation: false,
+			maintainAspectRatio: false,
+			plugins: {
+				title: {display: true, text: metric},
+				tooltip: {enabled: !lowbw.checked, callbacks: {label: ctx => ctx.dataset.label + ": " + formatValue(ctx.parsed.y, meta[metric])}},
+				legend: {onClick: (e, item) => {
+					if (item.text !== "anomaly") {
+						location.href = "/process/" + encodeURIComponent(item.text);
+					}
+				}}
+			},
+			scales: {
+				x: {type: "linear", ticks: {callback: v => new Date(v).toLocaleTimeString()}},
+				y: {ticks: {callback: v => formatValue(v, meta[metric])}}
+			}
+		}
+	});
+	return charts[metric];
+}
+
+// exportChart downloads the series of a chart within the current viewport.
+function exportChart(metric, format) {
+	const vp = viewport();
+	const params = new URLSearchParams({metric: metric, format: format, from: Math.floor(vp.min), to: Math.ceil(vp.max)});
+	params.set("step", stepSeconds() + "s");
+	charts[metric].data.datasets.forEach(d => {
+		if (d.label !== "anomaly") {
+			params.append("process", d.label);
+		}
+	});
+	location.href = "/api/v1/export?" + params;
+}
+
+function viewport() {
+	const end = view.end === null ? retention.max : view.end;
+	return {min: end - view.width, max: end};
+}
+
+function render() {
+	const [stats, anomalies] = last;
+	const names = Object.keys(stats).sort();
+	const metrics = new Set();
+	retention = {min: Infinity, max: -Infinity};
+	names.forEach(n => stats[n].forEach(s => {
+		Object.keys(s.metrics).forEach(m => metrics.add(m));
+		retention.min = Math.min(retention.min, s.time);
+		retention.max = Math.max(retention.max, s.time);
+	}));
+	const vp = viewport();
+	[...metrics].sort().forEach(metric => {
+		if (meta[metric] && meta[metric].unit === "state") {
+			drawStates(stats, names, metric);
+			return;
+		}
+		const chart = chartFor(metric);
+		const datasets = names.map((name, i) => ({
+			label: name,
+			data: stats[name].map(s => ({x: s.time, y: s.metrics[metric]})),
+			borderColor: colors[i % colors.length],
+			pointRadius: 0
+		}));
+		const marks = anomalies.filter(a => a.metric === metric);
+		if (marks.length > 0) {
+			datasets.push({
+				label: "anomaly",
+				data: marks.map(a => ({x: a.time, y: a.value})),
+				borderColor: "#d62728",
+				backgroundColor: "#d62728",
+				showLine: false,
+				pointRadius: 5
+			});
+		}
+		chart.data.datasets = datasets;
+		chart.options.scales.x.min = vp.min;
+		chart.options.scales.x.max = vp.max;
+		chart.update();
+	});
+	drawScrubber(stats, names, metrics.has("cpu") ? "cpu" : [...metrics].sort()[0]);
+}
+
+// drawStates shows the state in the viewport of each process as a chip
+// rather than a chart.
+function drawStates(stats, names, metric) {
+	const vp = viewport();
+	const p = document.getElementById("states");
+	p.replaceChildren();
+	names.forEach(name => {
+		const samples = stats[name].filter(s => s.time <= vp.max && s.metrics[metric] !== undefined);
+		if (samples.length === 0) {
+			return;
+		}
+		const label = document.createElement("span");
+		label.className = "process";
+		label.textContent = name;
+		p.append(label, stateChip(samples[samples.length - 1].metrics[metric]));
+	});
+}
+
+function drawScrubber(stats, names, metric) {
+	const canvas = document.getElementById("scrubber");
+	const ctx = canvas.getContext("2d");
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	const span = retention.max - retention.min;
+	if (!metric || !(span > 0)) {
+		return;
+	}
+	const x = t => (t - retention.min) / span * canvas.width;
+	names.forEach((name, i) => {
+		const values = stats[name].map(s => s.metrics[metric] || 0);
+		const top = Math.max(1, ...values);
+		ctx.strokeStyle = colors[i % colors.length];
+		ctx.beginPath();
+		stats[name].forEach((s, j) => {
+			const y = canvas.height - values[j] / top * (canvas.height - 4) - 2;
+			j === 0 ? ctx.moveTo(x(s.time), y) : ctx.lineTo(x(s.time), y);
+		});
+		ctx.stroke();
+	});
+	const vp = viewport();
+	ctx.fillStyle = "rgba(31, 119, 180, 0.2)";
+	ctx.fillRect(x(vp.min), 0, x(vp.max) - x(vp.min), canvas.height);
+	document.getElementById("scrubber-label").textContent =
+		new Date(vp.min).toLocaleTimeString() + " - " + new Date(vp.max).toLocaleTimeString() +
+		(view.end === null ? " (live)" : "") + ", " + Math.round(span / 1000) + "s retained (" + metric + ")";
+}
+
+function scrubTo(event) {
+	const canvas = document.getElementById("scrubber");
+	const rect = canvas.getBoundingClientRect();
+	const t = retention.min + (event.clientX - rect.left) / rect.width * (retention.max - retention.min);
+	const end = Math.max(retention.min + view.width, t + view.width / 2);
+	// Dragging to the right edge returns to following live data.
+	setView(end >= retention.max ? null : end);
+}
+
+function setView(end) {
+	view.end = end;
+	view.width = end === null ? rangeMs() : historyWidth;
+	document.getElementById("mode").value = end === null ? "live" : "history";
+	if (last) {
+		render();
+	}
+}
+
+// rangeMs is the selected time range, e.g. "15m" or a custom "90s", "2h".
+function rangeMs() {
+	let value = document.getElementById("range").value;
+	if (value === "custom") {
+		value = document.getElementById("custom-range").value.trim();
+	}
+	const m = /^(\d+)([smh])$/.exec(value);
+	if (!m) {
+		return 300000;
+	}
+	return m[1] * {s: 1000, m: 60000, h: 3600000}[m[2]];
+}
+
+// stepSeconds gives about 300 points per series whatever the range, or 60
+// in low bandwidth mode.
+function stepSeconds() {
+	const points = lowbw.checked ? 60 : 300;
+	return Math.max(1, Math.round(rangeMs() / 1000 / points));
+}
+
+function changeRange() {
+	document.getElementById("custom-range").style.display =
+		document.getElementById("range").value === "custom" ? "" : "none";
+	setView(null);
+	refresh();
+}
+
+document.getElementById("range").addEventListener("change", changeRange);
+document.getElementById("custom-range").addEventListener("change", changeRange);
+document.getElementById("mode").addEventListener("change", e => {
+	setView(e.target.value === "live" ? null : retention.max);
+});
+document.getElementById("pause").addEventListener("click", e => {
+	paused = !paused;
+	e.target.textContent = paused ? "Resume" : "Pause";
+	if (!paused) {
+		refresh();
+	}
+});
+
+let dragging = false;
+const scrubber = document.getElementById("scrubber");
+scrubber.addEventListener("mousedown", e => { dragging = true; scrubTo(e); });
+window.addEventListener("mousemove", e => { if (dragging) { scrubTo(e); } });
+window.addEventListener("mouseup", () => { dragging = false; });
+
+async function refresh() {
+	if (paused) {
+		return;
+	}
+	const [names, selection, anomalies, lifecycle, status] = await Promise.all([
+		fetch("/api/v1/processes?federated=1").then(r => r.json()),
+		fetch("/api/v1/metrics").then(r => r.json()),
+		fetch("/api/v1/anomalies").then(r => r.json()),
+		fetch("/api/v1/events").then(r => r.json()),
+		fetch("/api/v1/processes?status=1").then(r => r.json())
+	]);
+	events = lifecycle;
+	drawFeed();
+	drawWarnings(status);
+	const metrics = selection.selected.length > 0 ? selection.selected : selection.available;
+	meta = selection.meta;
+	const step = stepSeconds() + "s";
+	const queries = [];
+	names.forEach(process => metrics.forEach(metric =>
+		// a state averaged over a step means nothing
+		queries.push({process: process, metric: metric, range: rangeMs() / 1000 + "s",
+			step: meta[metric] && meta[metric].unit === "state" ? "" : step,
+			encoding: lowbw.checked ? "delta" : ""})));
+	const res = await fetch("/api/v1/query", {method: "POST", body: JSON.stringify({queries: queries})}).then(r => r.json());
+
+	// Regroup the series into samples per process, as /metrics returns them.
+	const stats = {};
+	const byTime = {};
+	names.forEach(name => stats[name] = []);
+	res.results.forEach(r => r.encoding === "delta" && r.points.forEach((p, i) => {
+		if (i > 0) {
+			p[0] += r.points[i - 1][0];
+			p[1] += r.points[i - 1][1];
+		}
+	}));
+	res.results.forEach(r => r.points.forEach(([t, v]) => {
+		const key = r.process + "/" + t;
+		if (!byTime[key]) {
+			byTime[key] = {time: t, metrics: {}};
+			stats[r.process].push(byTime[key]);
+		}
+		byTime[key].metrics[r.metric] = v;
+	}));
+	Object.values(stats).forEach(samples => samples.sort((a, b) => a.time - b.time));
+	last = [stats, anomalies];
+	render();
+}
+
+// drawFeed lists the events newest first, keeping the scroll position
+// unless it is at the top.
+function drawFeed() {
+	const feed = document.getElementById("feed");
+	const top = feed.scrollTop;
+	feed.replaceChildren(...events.slice().reverse().map(e => {
+		const line = document.createElement("div");
+		const kind = document.createElement("span");
+		kind.className = "event";
+		kind.style.color = eventColors[e.event] || "#000";
+		kind.textContent = e.event;
+		let text = " " + e.process;
+		if (e.pid) {
+			text += " pid " + e.pid;
+		}
+		if (e.old_pid) {
+			text += " (was " + e.old_pid + ")";
+		}
+		if (e.error) {
+			text += ": " + e.error;
+		}
+		line.append(new Date(e.time).toLocaleTimeString() + " ", kind, text);
+		return line;
+	}));
+	feed.style.display = events.length > 0 ? "" : "none";
+	feed.scrollTop = top;
+}
+
+// drawWarnings lists the metrics that couldn't be read, e.g. io of
+// another user's process.
+function drawWarnings(status) {
+	const box = document.getElementById("warnings");
+	const lines = [];
+	(status || []).forEach(t => Object.keys(t.metric_errors || {}).sort().forEach(m =>
+		lines.push(t.name + " " + m + ": " + t.metric_errors[m])));
+	box.style.display = lines.length > 0 ? "" : "none";
+	box.textContent = lines.length > 0 ? "Some metrics can't be read and are missing: " + lines.join(", ") : "";
+}
+
+let timer = null;
+
+function schedule() {
+	clearInterval(timer);
+	timer = setInterval(refresh, lowbw.checked ? 5000 : 1000);
+}
+
+lowbw.addEventListener("change", () => {
+	localStorage.setItem("lowbw", lowbw.checked ? "1" : "0");
+	Object.values(charts).forEach(chart => chart.options.plugins.tooltip.enabled = !lowbw.checked);
+	schedule();
+	refresh();
+});
+
+refresh();
+schedule();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type targetState struct {
+	Pids           []int                       `json:"pids"`
+	Initialized    bool                        `json:"initialized"`
+	PrevRaw        map[string]map[string]int64 `json:"prev_raw"`
+	LastScrape     time.Time                   `json:"last_scrape"`
+	LastDurationMs float64                     `json:"last_duration_ms"`
+	LastError      string                      `json:"last_error"`
+	Samples        int                         `json:"samples"`
+	LastSample     *Sample                     `json:"last_sample"`
+}
+
+type alertState struct {
+	Rule         string    `json:"rule"`
+	PendingSince time.Time `json:"pending_since"`
+	Firing       bool      `json:"firing"`
+}
+
+type anomalyBaseline struct {
+	Mean    float64 `json:"mean"`
+	Stddev  float64 `json:"stddev"`
+	Samples int     `json:"samples"`
+	Firing  bool    `json:"firing"`
+}
+
+type DebugState struct {
+	Time          time.Time                  `json:"time"`
+	MissedScrapes int64                      `json:"missed_scrapes"`
+	SampleBytes   int64                      `json:"sample_bytes"`
+	Targets       map[string]targetState     `json:"targets"`
+	Alerts        []alertState               `json:"alerts"`
+	Anomalies     map[string]anomalyBaseline `json:"anomalies"`
+}
+
+func GetDebugState() DebugState {
+	d := DebugState{
+		Time:          time.Now(),
+		MissedScrapes: atomic.LoadInt64(&missedScrapes),
+		SampleBytes:   GetSampleBytes(),
+		Targets:       make(map[string]targetState),
+		Anomalies:     make(map[string]anomalyBaseline),
+	}
+	// collectAll holds statsLock for writing while it updates rule state
+	// too, so reading both under the read lock is consistent.
+	statsLock.RLock()
+	for name, ps := range statsMap {
+		t := targetState{
+			Pids:           ps.Pids,
+			Initialized:    ps.Initialized(),
+			PrevRaw:        make(map[string]map[string]int64),
+			LastScrape:     ps.LastScrape,
+			LastDurationMs: float64(ps.LastDuration) / float64(time.Millisecond),
+			LastError:      ps.LastError,
+			Samples:        ps.Samples.Len(),
+		}
+		for pid, raw := range ps.Baselines() {
+			t.PrevRaw[strconv.Itoa(pid)] = raw
+		}
+		if s, ok := ps.Samples.Last(); ok {
+			t.LastSample = &s
+		}
+		d.Targets[name] = t
+	}
+	for _, r := range alertRules {
+		d.Alerts = append(d.Alerts, alertState{Rule: r.Text, PendingSince: r.pendingSince, Firing: r.firing})
+	}
+	statsLock.RUnlock()
+
+	anomalyLock.Lock()
+	for key, st := range anomalyState {
+		d.Anomalies[key] = anomalyBaseline{Mean: st.mean, Stddev: math.Sqrt(st.variance), Samples: st.n, Firing: st.firing}
+	}
+	anomalyLock.Unlock()
+	return d
+}
+
+// debugState is only served when some form of auth is configured, as it
+// exposes internals that have no business on an open port.
+func debugState(w http.ResponseWriter, req *http.Request) {
+	if !authConfigured() {
+		http.Error(w, "/debug/state requires -auth-user, -auth-htpasswd or -auth-token-file", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(GetDebugState())
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type MapEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Kind is "parent" (From spawned To) or "tcp" (From connects to a port
+	// To listens on over loopback).
+	Kind  string `json:"kind"`
+	Ports []int  `json:"ports,omitempty"`
+}
+
+type DependencyMap struct {
+	Nodes []string  `json:"nodes"`
+	Edges []MapEdge `json:"edges"`
+}
+
+func parentPid(pid int) int {
+	dat, err := readProcFile(procRoot + "/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	s := procmon.StatFields(string(dat))
+	if len(s) < 4 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(s[3])
+	return ppid
+}
+
+// socketInodes returns the inodes of the sockets pid has open.
+func socketInodes(pid int) ([]string, error) {
+	dir := procRoot + "/" + strconv.Itoa(pid) + "/fd"
+	fds, err := readProcDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var inodes []string
+	for _, fd := range fds {
+		link, err := readProcLink(dir + "/" + fd)
+		if err == nil && strings.HasPrefix(link, "socket:[") {
+			inodes = append(inodes, strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"))
+		}
+	}
+	return inodes, nil
+}
+
+type netSocket struct {
+	// local and remote are addr:port as in /proc/net/tcp and udp
+	local, remote string
+	localAddr     string
+	remoteAddr    string
+	localPort     int
+	state         string
+	inode         string
+}
+
+// isLoopback reports whether a /proc/net/tcp{,6} address (hex, in host
+// byte order per 32 bit word) is 127.0.0.0/8, ::1 or ::ffff:127.0.0.0/104.
+func isLoopback(addr string) bool {
+	switch len(addr) {
+	case 8:
+		return strings.HasSuffix(addr, "7F")
+	case 32:
+		return addr == "00000000000000000000000001000000" ||
+			(strings.HasPrefix(addr, "0000000000000000FFFF0000") && strings.HasSuffix(addr, "7F"))
+	}
+	return false
+}
+
+// readNetSockets parses the named socket tables of <dir>/net, e.g. "tcp"
+// and "tcp6".
+func readNetSockets(dir string, tables ...string) []netSocket {
+	var sockets []netSocket
+	for _, table := range tables {
+		dat, err := readProcFile(dir + "/net/" + table)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(dat), "\n")[1:] {
+			f := strings.Fields(line)
+			if len(f) < 10 {
+				continue
+			}
+			local := strings.SplitN(f[1], ":", 2)
+			remote := strings.SplitN(f[2], ":", 2)
+			if len(local) != 2 || len(remote) != 2 {
+				continue
+			}
+			port, _ := strconv.ParseInt(local[1], 16, 32)
+			sockets = append(sockets, netSocket{
+				local:      f[1],
+				remote:     f[2],
+				localAddr:  local[0],
+				remoteAddr: remote[0],
+				localPort:  int(port),
+				state:      f[3],
+				inode:      f[9],
+			})
+		}
+	}
+	return sockets
+}
+
+const (
+	tcpEstablished = "01"
+	tcpTimeWait    = "06"
+	tcpListen      = "0A"
+)
+
+// GetDependencyMap links the monitored targets by process ancestry and by
+// TCP connections between them over loopback, as seen from the exporter's
+// network namespace.
+func GetDependencyMap() DependencyMap {
+	m := DependencyMap{Nodes: TargetNames(), Edges: []MapEdge{}}
+	owner := make(map[int]string)
+	for _, name := range m.Nodes {
+		for _, pid := range ResolveTarget(name) {
+			if _, ok := owner[pid]; !ok {
+				owner[pid] = name
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	add := func(e MapEdge) {
+		key := e.From + "\x00" + e.To + "\x00" + e.Kind
+		if e.From == e.To || seen[key] {
+			return
+		}
+		seen[key] = true
+		m.Edges = append(m.Edges, e)
+	}
+	for pid, name := range owner {
+		// the nearest monitored ancestor
+		for p, depth := parentPid(pid), 0; p > 1 && depth < 64; p, depth = parentPid(p), depth+1 {
+			if parent, ok := owner[p]; ok {
+				add(MapEdge{From: parent, To: name, Kind: "parent"})
+				break
+			}
+		}
+	}
+
+	inodeOwner := make(map[string]string)
+	for pid, name := range owner {
+		inodes, _ := socketInodes(pid)
+		for _, inode := range inodes {
+			inodeOwner[inode] = name
+		}
+	}
+	sockets := readNetSockets(procRoot, "tcp", "tcp6")
+	listening := make(map[int]bool)
+	byEnds := make(map[string]netSocket)
+	for _, s := range sockets {
+		if s.state == tcpListen {
+			listening[s.localPort] = true
+		}
+		if s.state == tcpEstablished && isLoopback(s.localAddr) && isLoopback(s.remoteAddr) {
+			byEnds[s.local+" "+s.remote] = s
+		}
+	}
+	ports := make(map[string]map[int]bool)
+	for _, s := range byEnds {
+		// the client side, connected from a port nobody listens on
+		if listening[s.localPort] {
+			continue
+		}
+		peer, ok := byEnds[s.remote+" "+s.local]
+		from, to := inodeOwner[s.inode], inodeOwner[peer.inode]
+		if !ok || from == "" || to == "" || from == to {
+			continue
+		}
+		key := from + "\x00" + to
+		if ports[key] == nil {
+			ports[key] = make(map[int]bool)
+		}
+		ports[key][peer.localPort] = true
+	}
+	for key, set := range ports {
+		ends := strings.SplitN(key, "\x00", 2)
+		e := MapEdge{From: ends[0], To: ends[1], Kind: "tcp"}
+		for p := range set {
+			e.Ports = append(e.Ports, p)
+		}
+		sort.Ints(e.Ports)
+		add(e)
+	}
+	sort.Slice(m.Edges, func(i, j int) bool {
+		a, b := m.Edges[i], m.Edges[j]
+		return fmt.Sprint(a.From, a.To, a.Kind) < fmt.Sprint(b.From, b.To, b.Kind)
+	})
+	return m
+}
+
+func dependencyMapHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetDependencyMap())
+}
+
+func mapPage(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, mapHTML)
+}
+
+const mapHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>Dependency map</title>
+<style>
+body { font-family: sans-serif; }
+svg { border: 1px solid #ccc; }
+.node circle { fill: #1f77b4; }
+.node text { font-size: 13px; }
+.parent { stroke: #7f7f7f; stroke-dasharray: 4 3; }
+.tcp { stroke: #ff7f0e; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>Dependency map</h1>
+<p><a href="/">dashboard</a> | <span style="color: #7f7f7f">- - - spawned</span> | <span style="color: #ff7f0e">&mdash; connects to (port)</span></p>
+<svg id="map" width="800" height="600">
+<defs>
+<marker id="arrow" viewBox="0 0 10 10" refX="20" refY="5" markerWidth="8" markerHeight="8" orient="auto">
+<path d="M 0 0 L 10 5 L 0 10 z" fill="#555"/>
+</marker>
+</defs>
+</svg>
+<script>
+const svgNS = "http://www.w3.org/2000/svg";
+
+function el(name, attrs, parent) {
+	const e = document.createElementNS(svgNS, name);
+	Object.entries(attrs).forEach(([k, v]) => e.setAttribute(k, v));
+	parent.appendChild(e);
+	return e;
+}
+
+async function draw() {
+	const m = await fetch("/api/v1/map").then(r => r.json());
+	const svg = document.getElementById("map");
+	svg.querySelectorAll("g").forEach(g => g.remove());
+	const g = el("g", {}, svg);
+	// nodes on a circle, good enough for the handful of targets per host
+	const pos = {};
+	const cx = 400, cy = 300, r = m.nodes.length > 1 ? 220 : 0;
+	m.nodes.forEach((name, i) => {
+		const a = 2 * Math.PI * i / m.nodes.length - Math.PI / 2;
+		pos[name] = {x: cx + r * Math.cos(a), y: cy + r * Math.sin(a)};
+	});
+	m.edges.forEach(e => {
+		const a = pos[e.from], b = pos[e.to];
+		el("line", {x1: a.x, y1: a.y, x2: b.x, y2: b.y, "class": e.kind, "marker-end": "url(#arrow)"}, g);
+		if (e.ports) {
+			const t = el("text", {x: (a.x + b.x) / 2, y: (a.y + b.y) / 2 - 4, "font-size": 11}, g);
+			t.textContent = e.ports.join(", ");
+		}
+	});
+	m.nodes.forEach(name => {
+		const a = el("a", {href: "/process/" + encodeURIComponent(name), "class": "node"}, g);
+		el("circle", {cx: pos[name].x, cy: pos[name].y, r: 8}, a);
+		el("text", {x: pos[name].x + 12, y: pos[name].y + 4}, a).textContent = name;
+	});
+}
+
+draw();
+setInterval(draw, 10000);
+</script>
+</body>
+</html>
+`
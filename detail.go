@@ -0,0 +1,297 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ProcessDetail struct {
+	Name    string            `json:"name"`
+	Pids    []int             `json:"pids"`
+	Pid     int               `json:"pid"`
+	State   string            `json:"state"`
+	Started time.Time         `json:"started"`
+	Exe     string            `json:"exe,omitempty"`
+	Cmdline string            `json:"cmdline"`
+	Uid     int               `json:"uid"`
+	Gid     int               `json:"gid"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Signals []SignalAction    `json:"signals,omitempty"`
+	// Status is the last collection of the target, set by the API only.
+	Status *TargetStatus `json:"status,omitempty"`
+}
+
+// procStateOrder encodes the state metric: the index of the state plus 1,
+// 0 for unknown. stateJS has the same order.
+const procStateOrder = "RSDZTtXI"
+
+var procStates = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "disk sleep",
+	"Z": "zombie",
+	"T": "stopped",
+	"t": "tracing stop",
+	"X": "dead",
+	"I": "idle",
+}
+
+func (d ProcessDetail) Uptime() string {
+	if d.Started.IsZero() {
+		return ""
+	}
+	return time.Since(d.Started).Round(time.Second).String()
+}
+
+// GetProcessDetail describes the first process of a target.
+func GetProcessDetail(name string) ProcessDetail {
+	d := ProcessDetail{Name: name, Pids: ResolveTarget(name), Uid: -1, Gid: -1, Labels: TargetLabels(name), Signals: SignalActionsFor(name)}
+	if len(d.Pids) == 0 {
+		d.Error = "process not running"
+		return d
+	}
+	if !isGroupTarget(name) {
+		d.Pids = d.Pids[:1]
+	}
+	d.Pid = d.Pids[0]
+	dir := procRoot + "/" + strconv.Itoa(d.Pid)
+	dat, err := readProcFile(dir + "/stat")
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	fields := procmon.StatFields(string(dat))
+	if len(fields) < 22 {
+		d.Error = "truncated stat"
+		return d
+	}
+	d.State = fields[2]
+	if s, ok := procStates[fields[2]]; ok {
+		d.State += " (" + s + ")"
+	}
+	d.Started = GetStartTime(atoi64(fields[21]))
+	// arguments are NUL separated, with a trailing NUL
+	cmdline, err := readProcFile(dir + "/cmdline")
+	if err == nil {
+		d.Cmdline = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	}
+	// needs the same uid or CAP_SYS_PTRACE
+	d.Exe, _ = readProcLink(dir + "/exe")
+	status, err := readProcFile(dir + "/status")
+	if err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			f := strings.Fields(line)
+			if len(f) > 1 && f[0] == "Uid:" {
+				d.Uid = int(atoi64(f[1]))
+			} else if len(f) > 1 && f[0] == "Gid:" {
+				d.Gid = int(atoi64(f[1]))
+			}
+		}
+	}
+	return d
+}
+
+var processTemplate = template.Must(template.New("process").Funcs(template.FuncMap{
+	"formatValueJS": func() template.JS { return template.JS(formatValueJS) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Name}}</title>
+<script src="https://cdn.jsdelivr.net/npm/chart.js@4"></script>
+<style>
+body { font-family: sans-serif; }
+th, td { padding: 2px 8px; text-align: left; }
+.chart { width: 800px; height: 200px; margin-bottom: 20px; }
+.state { padding: 1px 8px; border-radius: 8px; color: #fff; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p><a href="/">dashboard</a> | <a href="/inventory?process={{.Name}}">inventory</a></p>
+{{if .Error}}<p>{{.Error}}</p>{{else}}
+<table>
+<tr><th>PID</th><td>{{.Pid}}{{if gt (len .Pids) 1}} (of {{len .Pids}}: {{range $i, $p := .Pids}}{{if $i}}, {{end}}{{$p}}{{end}}){{end}}</td></tr>
+<tr><th>State</th><td id="state">{{.State}}</td></tr>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th>Command line</th><td><code>{{.Cmdline}}</code></td></tr>
+</table>
+{{if .Signals}}<p>
+{{range .Signals}}<button class="signal" data-signal="{{.Signal}}">{{if .Label}}{{.Label}} ({{.Signal}}){{else}}Send {{.Signal}}{{end}}</button>
+{{end}}<span id="signal-result"></span>
+</p>{{end}}
+{{end}}
+<p>
+<button id="burst">Burst sample</button>
+every <input id="burst-interval" size="6" value="100ms">
+for <input id="burst-duration" size="6" value="30s">
+<span id="burst-status"></span>
+</p>
+<div id="burst-charts"></div>
+<div id="charts"></div>
+<script>
+const processName = {{.Name}};
+const charts = {};
+let meta = {};
+const colors = ["#1f77b4", "#ff7f0e", "#2ca02c", "#9467bd"];
+
+{{formatValueJS}}
+// chartFor returns the chart with the given id in container; the id is
+// also the title and the key of its units in meta.
+function chartFor(id, container) {
+	if (charts[id]) {
+		return charts[id];
+	}
+	const div = document.createElement("div");
+	div.className = "chart";
+	const canvas = document.createElement("canvas");
+	div.appendChild(canvas);
+	document.getElementById(container).appendChild(div);
+	charts[id] = new Chart(canvas, {
+		type: "line",
+		data: {datasets: []},
+		options: {
+			animation: false,
+			maintainAspectRatio: false,
+			plugins: {
+				title: {display: true, text: id},
+				legend: {display: false},
+				tooltip: {callbacks: {label: ctx => formatValue(ctx.parsed.y, meta[id])}}
+			},
+			scales: {
+				x: {type: "linear", ticks: {callback: v => new Date(v).toLocaleTimeString()}},
+				y: {ticks: {callback: v => formatValue(v, meta[id])}}
+			}
+		}
+	});
+	return charts[id];
+}
+
+function draw(id, container, samples, metric) {
+	const chart = chartFor(id, container);
+	chart.data.datasets = [{
+		label: id,
+		data: samples.map(s => ({x: s.time, y: s.metrics[metric]})),
+		borderColor: "#1f77b4",
+		pointRadius: 0
+	}];
+	chart.update();
+}
+
+// drawStacked draws the metrics of samples as stacked areas in one chart.
+function drawStacked(id, container, samples, metrics) {
+	const chart = chartFor(id, container);
+	chart.options.scales.y.stacked = true;
+	chart.options.plugins.legend.display = true;
+	meta[id] = meta[metrics[0]];
+	chart.data.datasets = metrics.map((metric, i) => ({
+		label: metric,
+		data: samples.map(s => ({x: s.time, y: s.metrics[metric]})),
+		borderColor: colors[i % colors.length],
+		backgroundColor: colors[i % colors.length] + "80",
+		fill: i === 0 ? "origin" : "-1",
+		pointRadius: 0
+	}));
+	chart.update();
+}
+
+let burstRunning = false;
+
+async function refreshBurst() {
+	const r = await fetch("/api/v1/processes/" + encodeURIComponent(processName) + "/burst");
+	if (!r.ok) {
+		return;
+	}
+	const burst = await r.json();
+	burstRunning = burst.running;
+	document.getElementById("burst-status").textContent = burst.running ?
+		"sampling every " + burst.interval + " until " + new Date(burst.until).toLocaleTimeString() :
+		burst.samples.length + " samples every " + burst.interval + " from " + new Date(burst.started).toLocaleTimeString();
+	const metrics = new Set();
+	burst.samples.forEach(s => Object.keys(s.metrics).forEach(m => metrics.add(m)));
+	[...metrics].sort().forEach(metric => {
+		meta["burst " + metric] = (burst.meta || {})[metric];
+		draw("burst " + metric, "burst-charts", burst.samples, metric);
+	});
+}
+
+document.getElementById("burst").addEventListener("click", async () => {
+	const r = await fetch("/api/v1/processes/" + encodeURIComponent(processName) + "/burst", {
+		method: "POST",
+		body: JSON.stringify({
+			interval: document.getElementById("burst-interval").value,
+			duration: document.getElementById("burst-duration").value
+		})
+	});
+	if (!r.ok) {
+		document.getElementById("burst-status").textContent = await r.text();
+		return;
+	}
+	refreshBurst();
+});
+
+async function refresh() {
+	const [stats, selection] = await Promise.all([
+		fetch("/metrics?time_format=unix_ms&process=" + encodeURIComponent(processName)).then(r => r.json()),
+		fetch("/api/v1/metrics").then(r => r.json())
+	]);
+	Object.assign(meta, selection.meta);
+	const samples = stats[processName] || [];
+	const metrics = new Set();
+	samples.forEach(s => Object.keys(s.metrics).forEach(m => metrics.add(m)));
+	// anon and file add up to rss; shared and locked overlap them
+	const breakdown = ["smaps_anon", "smaps_file"].filter(m => metrics.has(m));
+	if (breakdown.length > 0) {
+		drawStacked("memory breakdown", "charts", samples, breakdown);
+	}
+	[...metrics].sort().forEach(metric => {
+		if (breakdown.includes(metric)) {
+			return;
+		}
+		if (meta[metric] && meta[metric].unit === "state") {
+			const td = document.getElementById("state");
+			if (td && samples.length > 0) {
+				td.replaceChildren(stateChip(samples[samples.length - 1].metrics[metric]));
+			}
+			return;
+		}
+		draw(metric, "charts", samples, metric);
+	});
+	if (burstRunning) {
+		refreshBurst();
+	}
+}
+
+document.querySelectorAll("button.signal").forEach(button => button.addEventListener("click", async () => {
+	const signal = button.dataset.signal;
+	if (!confirm("Send " + signal + " to " + processName + "?")) {
+		return;
+	}
+	const r = await fetch("/api/v1/processes/" + encodeURIComponent(processName) + "/signal",
+		{method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify({signal: signal})});
+	document.getElementById("signal-result").textContent = r.ok ?
+		signal + " sent to pid " + (await r.json()).pid : await r.text();
+}));
+
+refresh();
+refreshBurst();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`))
+
+// processPage serves /process/<name>.
+func processPage(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/process/")
+	if !HasTarget(name) {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	processTemplate.Execute(w, GetProcessDetail(name))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// dockerSocket is the Docker API socket "container:<name>" targets are
+// resolved through.
+var dockerSocket = "/var/run/docker.sock"
+
+var dockerClient = &http.Client{
+	Timeout: 2 * time.Second,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", dockerSocket)
+		},
+	},
+}
+
+var (
+	dockerErrLock sync.Mutex
+	dockerErrs    = make(map[string]string)
+)
+
+// logDockerError prints the error resolving a container when it changes,
+// rather than every interval.
+func logDockerError(name string, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	dockerErrLock.Lock()
+	defer dockerErrLock.Unlock()
+	if dockerErrs[name] != msg && msg != "" {
+		fmt.Println("container", name+":", msg)
+	}
+	dockerErrs[name] = msg
+}
+
+// Containers are looked up by a background goroutine every dockerRefresh,
+// so that collection cycles and HTTP handlers never wait for the Docker
+// API. A container is looked up until it hasn't been asked for in
+// dockerForget.
+var (
+	dockerRefresh = time.Second
+	dockerForget  = time.Minute
+	dockerLock    sync.Mutex
+	dockerPids    = make(map[string]dockerEntry)
+	dockerWake    = make(chan struct{}, 1)
+	dockerStart   sync.Once
+)
+
+type dockerEntry struct {
+	pid    int
+	known  bool
+	wanted time.Time
+}
+
+// containerPids returns the main pid of a running container, in the pid
+// namespace of the Docker daemon, as last looked up. A container asked for
+// the first time has no pid until the lookup is done.
+func containerPids(name string) []int {
+	dockerStart.Do(func() { go runDockerResolver() })
+	dockerLock.Lock()
+	e := dockerPids[name]
+	e.wanted = time.Now()
+	dockerPids[name] = e
+	dockerLock.Unlock()
+	if !e.known {
+		select {
+		case dockerWake <- struct{}{}:
+		default:
+		}
+	}
+	if e.pid == 0 {
+		return nil
+	}
+	return []int{e.pid}
+}
+
+func runDockerResolver() {
+	ticker := time.NewTicker(dockerRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-dockerWake:
+		}
+		var names []string
+		dockerLock.Lock()
+		for name, e := range dockerPids {
+			if time.Since(e.wanted) > dockerForget {
+				delete(dockerPids, name)
+				continue
+			}
+			names = append(names, name)
+		}
+		dockerLock.Unlock()
+		for _, name := range names {
+			pid, err := containerPid(name)
+			logDockerError(name, err)
+			dockerLock.Lock()
+			if e, ok := dockerPids[name]; ok {
+				e.known = true
+				if err == nil {
+					e.pid = pid
+				}
+				dockerPids[name] = e
+			}
+			dockerLock.Unlock()
+		}
+	}
+}
+
+func containerPid(name string) (int, error) {
+	resp, err := dockerClient.Get("http://docker/containers/" + url.PathEscape(name) + "/json")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("docker: %s", resp.Status)
+	}
+	var c struct {
+		State struct {
+			Running bool
+			Pid     int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return 0, err
+	}
+	if !c.State.Running {
+		return 0, nil
+	}
+	return c.State.Pid, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const maxEvents = 1000
+
+var (
+	eventsLock sync.Mutex
+	events     []LifecycleEvent
+)
+
+func recordEvent(e LifecycleEvent) {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	events = append(events, e)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+}
+
+// GetEvents returns the lifecycle events of process, or of every target if
+// process is empty, oldest first.
+func GetEvents(process string) []LifecycleEvent {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	result := []LifecycleEvent{}
+	for _, e := range events {
+		if process == "" || e.Process == process {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func eventsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetEvents(req.URL.Query().Get("process")))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportHandler serves /api/v1/export?metric=cpu&process=a&process=b
+// &from=&to=&step=&format=csv|json as a download. Without process every
+// target is exported. CSV times are always RFC 3339, JSON ones follow
+// ?time_format=.
+func exportHandler(w http.ResponseWriter, req *http.Request) {
+	v := req.URL.Query()
+	metric := v.Get("metric")
+	if metric == "" {
+		http.Error(w, "metric is required", http.StatusBadRequest)
+		return
+	}
+	times, err := requestTimeFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	processes := v["process"]
+	if len(processes) == 0 {
+		processes = TargetNames()
+	}
+	from, _ := strconv.ParseInt(v.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(v.Get("to"), 10, 64)
+	results := make([]QueryResult, 0, len(processes))
+	for _, p := range processes {
+		results = append(results, RunQuery(Query{
+			Process: p,
+			Metric:  metric,
+			Range:   v.Get("range"),
+			Step:    v.Get("step"),
+			From:    from,
+			To:      to,
+		}))
+	}
+
+	format := v.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	filename := fmt.Sprintf("procmon-%s-%s.%s", metric, time.Now().Format("20060102-150405"), format)
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if times == "rfc3339" {
+			json.NewEncoder(w).Encode(map[string][]exportResult{"results": exportResults(results)})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]QueryResult{"results": results})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "process", "metric", "value"})
+		for _, r := range results {
+			for _, p := range r.Points {
+				cw.Write([]string{
+					formatMillis(int64(p[0])),
+					r.Process,
+					metric,
+					strconv.FormatFloat(p[1], 'f', -1, 64),
+				})
+			}
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+	}
+}
+
+// exportResult is a QueryResult with [RFC 3339 time, value] points.
+type exportResult struct {
+	Query
+	Points [][2]interface{} `json:"points"`
+	Error  string           `json:"error,omitempty"`
+}
+
+func exportResults(results []QueryResult) []exportResult {
+	converted := make([]exportResult, len(results))
+	for i, r := range results {
+		converted[i] = exportResult{Query: r.Query, Points: make([][2]interface{}, len(r.Points)), Error: r.Error}
+		for j, p := range r.Points {
+			converted[i].Points[j] = [2]interface{}{formatMillis(int64(p[0])), p[1]}
+		}
+	}
+	return converted
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"math"
+	"time"
+)
+
+// minInterval is the shortest collection interval accepted.
+const minInterval = 100 * time.Millisecond
+
+// fastPath is set for intervals under a second: only the metrics read from
+// stat and statm are collected, so that a cycle stays well inside the
+// interval. Set under statsLock by ApplyConfig.
+var fastPath bool
+
+// collectedMetric reports whether c is collected at the current interval.
+func collectedMetric(c procmon.MetricCollector) bool {
+	if !fastPath {
+		return true
+	}
+	for _, src := range c.Sources() {
+		if src != "stat" && src != "statm" {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeDeltas scales the per interval deltas of s, taken elapsed after
+// the previous sample, to what they would be over exactly interval. Timer
+// jitter is a large part of a 100ms interval, and a skipped cycle doubles
+// the time a delta covers.
+func normalizeDeltas(s Sample, elapsed, interval time.Duration) {
+	if elapsed <= 0 || elapsed == interval {
+		return
+	}
+	f := float64(interval) / float64(elapsed)
+	for name, v := range s.Metrics {
+		if isDeltaMetric(name) {
+			s.Metrics[name] = int64(math.Round(float64(v) * f))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"strconv"
+	"strings"
+)
+
+var fdinfoMetrics = []string{
+	"fds",
+	"inotify_instances",
+	"inotify_watches",
+	"epoll_instances",
+	"epoll_watches",
+	"timerfds",
+	"timerfds_armed",
+}
+
+// fdTypes are the per type counts of open fds, reported with
+// "fd_types": true.
+var fdTypes = []string{
+	"fds_file",
+	"fds_socket",
+	"fds_pipe",
+	"fds_epoll",
+	"fds_anon_inode",
+	"fds_other",
+}
+
+var fdTypesEnabled bool
+
+// fd and fdinfo need the same uid or CAP_SYS_PTRACE; without, the
+// permission error leaves the metrics out rather than failing the sample.
+func init() {
+	for _, name := range append(fdinfoMetrics, fdTypes...) {
+		name := name
+		optional := strings.HasPrefix(name, "fds_")
+		procmon.Register(procmon.Metric{MetricName: name, Files: []string{"fd/", "fdinfo/"}, Read: func(pid int) (int64, error) {
+			if optional && !fdTypesEnabled {
+				return 0, procmon.ErrUnavailable
+			}
+			m, err := cached(pid, "fdinfo", func() (interface{}, error) {
+				return GetFdinfoStats(pid)
+			})
+			if err != nil {
+				return 0, err
+			}
+			return m.(map[string]int64)[name], nil
+		}})
+	}
+}
+
+// fdType classifies the target of an fd link.
+func fdType(link string) string {
+	switch {
+	case strings.HasPrefix(link, "/"):
+		return "fds_file"
+	case strings.HasPrefix(link, "socket:"):
+		return "fds_socket"
+	case strings.HasPrefix(link, "pipe:"):
+		return "fds_pipe"
+	case link == "anon_inode:[eventpoll]":
+		return "fds_epoll"
+	case strings.HasPrefix(link, "anon_inode:"):
+		return "fds_anon_inode"
+	}
+	return "fds_other"
+}
+
+// GetFdinfoStats walks /proc/<pid>/fd, counting the fds by type and, for
+// anonymous inode fds, the entries of the matching /proc/<pid>/fdinfo file.
+func GetFdinfoStats(pid int) (map[string]int64, error) {
+	dir := procRoot + "/" + strconv.Itoa(pid)
+	fds, err := readProcDir(dir + "/fd")
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int64)
+	for _, fd := range fds {
+		link, err := readProcLink(dir + "/fd/" + fd)
+		if err != nil {
+			continue
+		}
+		m["fds"]++
+		m[fdType(link)]++
+		switch link {
+		case "anon_inode:inotify":
+			m["inotify_instances"]++
+			m["inotify_watches"] += countFdinfoLines(dir+"/fdinfo/"+fd, "inotify wd:")
+		case "anon_inode:[eventpoll]":
+			m["epoll_instances"]++
+			m["epoll_watches"] += countFdinfoLines(dir+"/fdinfo/"+fd, "tfd:")
+		case "anon_inode:[timerfd]":
+			m["timerfds"]++
+			if timerfdArmed(dir + "/fdinfo/" + fd) {
+				m["timerfds_armed"]++
+			}
+		}
+	}
+	return m, nil
+}
+
+func countFdinfoLines(filename string, prefix string) int64 {
+	dat, err := readProcFile(filename)
+	if err != nil {
+		return 0
+	}
+	var n int64
+	for _, line := range strings.Split(string(dat), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// timerfdArmed reports whether the it_value line of a timerfd fdinfo file,
+// e.g. "it_value: (0, 499911283)", is non zero.
+func timerfdArmed(filename string) bool {
+	dat, err := readProcFile(filename)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if strings.HasPrefix(line, "it_value:") {
+			return strings.TrimSpace(line[len("it_value:"):]) != "(0, 0)"
+		}
+	}
+	return false
+}
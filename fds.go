@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fdsAPIEnabled serves /api/v1/processes/<target>/fds, which reads every fd
+// and the socket tables of the process and shows file names.
+var fdsAPIEnabled bool
+
+// OpenFd is an open file descriptor of a process, as lsof lists it.
+type OpenFd struct {
+	Pid    int    `json:"pid"`
+	Fd     int    `json:"fd"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	// Proto, Local, Remote and State are set for TCP and UDP sockets.
+	Proto  string `json:"proto,omitempty"`
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	State  string `json:"state,omitempty"`
+}
+
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// decodeProcAddr turns an addr:port of /proc/net/tcp, hex in host byte
+// order per 32 bit word, into e.g. "127.0.0.1:8080".
+func decodeProcAddr(s string) string {
+	hp := strings.SplitN(s, ":", 2)
+	b, err := hex.DecodeString(hp[0])
+	if err != nil || len(hp) != 2 || len(b)%4 != 0 {
+		return s
+	}
+	if nativeEndian == binary.LittleEndian {
+		for i := 0; i < len(b); i += 4 {
+			b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+		}
+	}
+	port, _ := strconv.ParseInt(hp[1], 16, 32)
+	return net.JoinHostPort(net.IP(b).String(), strconv.Itoa(int(port)))
+}
+
+// GetOpenFds lists the open fds of pid, with the addresses of its TCP and
+// UDP sockets looked up in the tables of its network namespace.
+func GetOpenFds(pid int) ([]OpenFd, error) {
+	dir := procRoot + "/" + strconv.Itoa(pid)
+	names, err := readProcDir(dir + "/fd")
+	if err != nil {
+		return nil, err
+	}
+	var result []OpenFd
+	wantSockets := false
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		link, err := readProcLink(dir + "/fd/" + name)
+		if err != nil {
+			// closed meanwhile
+			continue
+		}
+		t := strings.TrimPrefix(fdType(link), "fds_")
+		wantSockets = wantSockets || t == "socket"
+		result = append(result, OpenFd{Pid: pid, Fd: fd, Type: t, Target: link})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Fd < result[j].Fd })
+	if !wantSockets {
+		return result, nil
+	}
+	type socketInfo struct {
+		proto string
+		netSocket
+	}
+	byInode := make(map[string]socketInfo)
+	for _, proto := range []string{"tcp", "udp"} {
+		for _, s := range readNetSockets(dir, proto, proto+"6") {
+			byInode[s.inode] = socketInfo{proto, s}
+		}
+	}
+	for i, f := range result {
+		s, ok := byInode[strings.TrimSuffix(strings.TrimPrefix(f.Target, "socket:["), "]")]
+		if f.Type != "socket" || !ok {
+			continue
+		}
+		result[i].Proto = s.proto
+		result[i].Local = decodeProcAddr(s.local)
+		result[i].Remote = decodeProcAddr(s.remote)
+		if s.proto == "tcp" {
+			result[i].State = tcpStates[s.state]
+		}
+	}
+	return result, nil
+}
+
+// fdsHandler serves GET /api/v1/processes/<target>/fds.
+func fdsHandler(w http.ResponseWriter, req *http.Request, processName string) {
+	if !fdsAPIEnabled {
+		http.Error(w, "the fds endpoint is disabled, see -enable-fds-api", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !HasTarget(processName) {
+		http.Error(w, processName+" is not monitored", http.StatusNotFound)
+		return
+	}
+	pids := ResolveTarget(processName)
+	if len(pids) == 0 {
+		http.Error(w, processName+" is not running", http.StatusNotFound)
+		return
+	}
+	if !isGroupTarget(processName) {
+		pids = pids[:1]
+	}
+	result := []OpenFd{}
+	for _, pid := range pids {
+		fds, err := GetOpenFds(pid)
+		if os.IsPermission(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		result = append(result, fds...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// peers are the exporters whose samples are pulled from their /metrics
+// every interval, set with "peers" in the config. Their targets show up
+// here as <target>@<host> on the dashboard and the query API, and with a
+// host label on /prometheus.
+var peers []PeerConfig
+
+// PeerConfig is an entry of "peers": the URL of an exporter, or
+//
+//	{"url": "http://db1:8090", "token_file": "/etc/procmon/db1.token"}
+//
+// to send the bearer token in the file to a peer that requires one.
+// Credentials in the URL are sent as basic auth.
+type PeerConfig struct {
+	URL       string `json:"url"`
+	TokenFile string `json:"token_file,omitempty"`
+	// token is read from TokenFile when the config is applied
+	token string
+}
+
+type plainPeerConfig PeerConfig
+
+func (p *PeerConfig) UnmarshalJSON(dat []byte) error {
+	if len(dat) > 0 && dat[0] == '"' {
+		*p = PeerConfig{}
+		return json.Unmarshal(dat, &p.URL)
+	}
+	return json.Unmarshal(dat, (*plainPeerConfig)(p))
+}
+
+// MarshalJSON writes peers without a token file as plain URLs.
+func (p PeerConfig) MarshalJSON() ([]byte, error) {
+	if p.TokenFile == "" {
+		return json.Marshal(p.URL)
+	}
+	return json.Marshal(plainPeerConfig(p))
+}
+
+type peerState struct {
+	host string
+	// since is the time of the last sample pulled, or of the last push
+	since     int64
+	series    map[string][]Sample
+	lastError string
+}
+
+var (
+	federationLock sync.RWMutex
+	peerStates     = make(map[string]*peerState)
+	peerClient     = &http.Client{Timeout: 5 * time.Second}
+)
+
+// checkPeer validates the URL of peer and reads its token.
+func checkPeer(peer *PeerConfig) error {
+	u, err := url.Parse(peer.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad peer %q", peer.URL)
+	}
+	if peer.TokenFile != "" {
+		token, err := ReadPasswordFile(peer.TokenFile)
+		if err != nil {
+			return fmt.Errorf("peer %s: %v", peer.URL, err)
+		}
+		peer.token = token
+	}
+	return nil
+}
+
+// RunFederation pulls the peers every interval.
+func RunFederation() {
+	for {
+		statsLock.RLock()
+		pulled := peers
+		interval := collectInterval
+		retention := maxSamples
+		statsLock.RUnlock()
+		var wg sync.WaitGroup
+		urls := make(map[string]bool)
+		for _, peer := range pulled {
+			urls[peer.URL] = true
+			wg.Add(1)
+			go func(peer PeerConfig) {
+				defer wg.Done()
+				pullPeer(peer, retention)
+			}(peer)
+		}
+		wg.Wait()
+		federationLock.Lock()
+		for peer := range peerStates {
+			// agents pushing to /api/v1/push stay
+			if !strings.HasPrefix(peer, "push:") && !urls[peer] {
+				delete(peerStates, peer)
+			}
+		}
+		federationLock.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+// pullPeer appends the samples peer took since the last pull.
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// checkSamples rejects samples of another exporter with metric names that
+// aren't valid in the Prometheus exposition, as they are written to
+// /prometheus as they are, or process names that can't be label values.
+func checkSamples(samples map[string][]Sample) error {
+	for name, ss := range samples {
+		if !validLabelValue(name) {
+			return fmt.Errorf("bad process name %q", name)
+		}
+		for _, s := range ss {
+			for m := range s.Metrics {
+				if !metricNameRE.MatchString(m) {
+					return fmt.Errorf("%s: bad metric name %q", name, m)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validLabelValue reports whether v, a process or host name of another
+// exporter, is fit for a label: non-empty UTF-8 without control characters.
+func validLabelValue(v string) bool {
+	return v != "" && utf8.ValidString(v) && strings.IndexFunc(v, unicode.IsControl) < 0
+}
+
+func pullPeer(peer PeerConfig, retention int) {
+	federationLock.RLock()
+	st := peerStates[peer.URL]
+	federationLock.RUnlock()
+	if st == nil {
+		u, _ := url.Parse(peer.URL)
+		st = &peerState{host: u.Host, series: make(map[string][]Sample)}
+	}
+	var result map[string][]Sample
+	// unix_ms whatever time_format the peer is configured with
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(peer.URL, "/")+"/metrics?time_format=unix_ms&since="+strconv.FormatInt(st.since, 10), nil)
+	var resp *http.Response
+	if err == nil {
+		if peer.token != "" {
+			req.Header.Set("Authorization", "Bearer "+peer.token)
+		}
+		// user:password@ in the URL is sent as basic auth
+		resp, err = peerClient.Do(req)
+	}
+	if err == nil {
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s", resp.Status)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+	}
+	if err == nil {
+		err = checkSamples(result)
+	}
+	federationLock.Lock()
+	defer federationLock.Unlock()
+	if err != nil {
+		if st.lastError != err.Error() {
+			fmt.Println("federation:", peer.URL+":", err)
+		}
+		st.lastError = err.Error()
+		peerStates[peer.URL] = st
+		return
+	}
+	st.lastError = ""
+	series := make(map[string][]Sample)
+	for name, samples := range result {
+		samples = append(st.series[name], samples...)
+		if len(samples) > retention {
+			samples = samples[len(samples)-retention:]
+		}
+		series[name] = samples
+		if len(samples) > 0 && samples[len(samples)-1].Time > st.since {
+			st.since = samples[len(samples)-1].Time
+		}
+	}
+	st.series = series
+	peerStates[peer.URL] = st
+}
+
+// GetFederatedMetrics returns the samples of the peers' targets by
+// <target>@<host>.
+func GetFederatedMetrics() map[string][]Sample {
+	federationLock.RLock()
+	defer federationLock.RUnlock()
+	result := make(map[string][]Sample)
+	for _, st := range peerStates {
+		for name, samples := range st.series {
+			result[name+"@"+st.host] = append([]Sample(nil), samples...)
+		}
+	}
+	return result
+}
+
+// FederatedTargets returns the <target>@<host> names of the peers' targets.
+func FederatedTargets() []string {
+	federationLock.RLock()
+	defer federationLock.RUnlock()
+	var names []string
+	for _, st := range peerStates {
+		for name := range st.series {
+			names = append(names, name+"@"+st.host)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// federatedTarget splits a <target>@<host> name of a peer's target.
+func federatedTarget(name string) (target string, host string, ok bool) {
+	i := strings.LastIndex(name, "@")
+	if i < 0 {
+		return "", "", false
+	}
+	federationLock.RLock()
+	defer federationLock.RUnlock()
+	for _, st := range peerStates {
+		if st.host == name[i+1:] && st.series[name[:i]] != nil {
+			return name[:i], st.host, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPeerConfigJSON(t *testing.T) {
+	var got []PeerConfig
+	dat := `["http://db1:8090", {"url": "http://db2:8090", "token_file": "/etc/db2.token"}]`
+	if err := json.Unmarshal([]byte(dat), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []PeerConfig{{URL: "http://db1:8090"}, {URL: "http://db2:8090", TokenFile: "/etc/db2.token"}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	out, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `["http://db1:8090",{"url":"http://db2:8090","token_file":"/etc/db2.token"}]` {
+		t.Errorf("marshalled as %s", out)
+	}
+}
+
+func TestPullPeerToken(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"nginx": [{"time": 1000, "pid": 7, "metrics": {"rss": 4096}}]}`))
+	}))
+	defer peer.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	without := PeerConfig{URL: peer.URL}
+	with := PeerConfig{URL: peer.URL + "/", TokenFile: tokenFile}
+	if err := checkPeer(&with); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		federationLock.Lock()
+		delete(peerStates, without.URL)
+		delete(peerStates, with.URL)
+		federationLock.Unlock()
+	}()
+
+	pullPeer(without, 10)
+	federationLock.RLock()
+	lastError := peerStates[without.URL].lastError
+	federationLock.RUnlock()
+	if lastError == "" {
+		t.Error("pull without the token succeeded")
+	}
+
+	pullPeer(with, 10)
+	federationLock.RLock()
+	st := peerStates[with.URL]
+	federationLock.RUnlock()
+	if st.lastError != "" {
+		t.Fatal(st.lastError)
+	}
+	if s := st.series["nginx"]; len(s) != 1 || s[0].Metrics["rss"] != 4096 {
+		t.Errorf("pulled %+v", st.series)
+	}
+}
+
+func TestPullPeerBadMetricName(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"nginx": [{"time": 1000, "pid": 7, "metrics": {"rss": 4096, "rss{evil=\"1\"} 9\nx": 1}}]}`))
+	}))
+	defer peer.Close()
+	p := PeerConfig{URL: peer.URL}
+	defer func() {
+		federationLock.Lock()
+		delete(peerStates, p.URL)
+		federationLock.Unlock()
+	}()
+
+	pullPeer(p, 10)
+	federationLock.RLock()
+	st := peerStates[p.URL]
+	federationLock.RUnlock()
+	if st.lastError == "" || len(st.series) != 0 {
+		t.Errorf("samples with a bad metric name accepted: %+v", st.series)
+	}
+}
+
+func TestPushHandlerNames(t *testing.T) {
+	oldReceive, oldTokens := receiveMode, bearerTokens
+	receiveMode, bearerTokens = true, []string{"t0k"}
+	defer func() {
+		receiveMode, bearerTokens = oldReceive, oldTokens
+		federationLock.Lock()
+		delete(peerStates, "push:web1")
+		federationLock.Unlock()
+	}()
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"host": "web1", "samples": {"nginx": [{"time": 1000, "metrics": {"rss": 1}}]}}`, http.StatusOK},
+		{`{"host": "web1", "samples": {"nginx": [{"time": 1000, "metrics": {"rss 1\nevil": 1}}]}}`, http.StatusBadRequest},
+		{`{"host": "web1", "samples": {"bad\nname": [{"time": 1000, "metrics": {"rss": 1}}]}}`, http.StatusBadRequest},
+		{`{"host": "web\u0000", "samples": {"nginx": [{"time": 1000, "metrics": {"rss": 1}}]}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		pushHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/push", strings.NewReader(tt.body)))
+		if rec.Code/100 != tt.want/100 {
+			t.Errorf("%s: %d %s, want %d", tt.body, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
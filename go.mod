@@ -1,5 +1,5 @@
 module github.com/colmo23/linux-proc-exporter
 
-go 1.13
+go 1.16
 
-require github.com/mitchellh/go-ps v1.0.0
+require golang.org/x/crypto v0.1.0
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// standardMetrics are charted when the config does not select metrics.
+var standardMetrics = []string{
+	"cpu", "rss", "vsize", "threads",
+	"inotify_instances", "inotify_watches", "epoll_instances", "epoll_watches",
+	"timerfds", "timerfds_armed", "restart_pending",
+	"ctx_switch_voluntary", "ctx_switch_involuntary",
+}
+
+func grafanaUnit(metric string) string {
+	if isByteMetric(metric) {
+		return "bytes"
+	}
+	return "short"
+}
+
+// grafanaTarget returns the panel query for metric, filtered by the
+// $process dashboard variable.
+func grafanaTarget(datasource string, metric string) map[string]interface{} {
+	if datasource == "influx" {
+		return map[string]interface{}{
+			"refId":        "A",
+			"rawQuery":     true,
+			"resultFormat": "time_series",
+			"alias":        "$tag_process",
+			"query": fmt.Sprintf(`SELECT mean("%s") FROM "procmon" WHERE ("process" =~ /^$process$/) AND $timeFilter GROUP BY time($__interval), "process" fill(null)`,
+				metric),
+		}
+	}
+	return map[string]interface{}{
+		"refId":        "A",
+		"expr":         fmt.Sprintf(`procmon_%s{process=~"$process"}`, metric),
+		"legendFormat": "{{process}}",
+	}
+}
+
+// GrafanaDashboard builds an importable dashboard with a $process variable
+// over targets and a time series panel per metric, querying the Prometheus
+// (/prometheus) or Influx (line protocol push) output.
+func GrafanaDashboard(targets []string, metrics []string, datasource string) (map[string]interface{}, error) {
+	var dsType, dsName string
+	switch datasource {
+	case "prometheus":
+		dsType, dsName = "prometheus", "DS_PROMETHEUS"
+	case "influx":
+		dsType, dsName = "influxdb", "DS_INFLUXDB"
+	default:
+		return nil, fmt.Errorf("unknown datasource %q, want prometheus or influx", datasource)
+	}
+	ds := map[string]string{"type": dsType, "uid": "${" + dsName + "}"}
+
+	var options []map[string]interface{}
+	for _, t := range targets {
+		options = append(options, map[string]interface{}{"text": t, "value": t, "selected": false})
+	}
+	process := map[string]interface{}{
+		"name":       "process",
+		"label":      "Process",
+		"type":       "custom",
+		"query":      strings.Join(targets, ","),
+		"options":    options,
+		"multi":      true,
+		"includeAll": true,
+		"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+
+	var panels []map[string]interface{}
+	for i, m := range metrics {
+		panels = append(panels, map[string]interface{}{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      m,
+			"datasource": ds,
+			"gridPos":    map[string]int{"x": (i % 2) * 12, "y": (i / 2) * 8, "w": 12, "h": 8},
+			"fieldConfig": map[string]interface{}{
+				"defaults":  map[string]string{"unit": grafanaUnit(m)},
+				"overrides": []interface{}{},
+			},
+			"targets": []interface{}{grafanaTarget(datasource, m)},
+		})
+	}
+
+	return map[string]interface{}{
+		"__inputs": []map[string]string{{
+			"name":     dsName,
+			"label":    datasource,
+			"type":     "datasource",
+			"pluginId": dsType,
+		}},
+		"title":         "linux-proc-exporter",
+		"tags":          []string{"procmon"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "10s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"templating":    map[string]interface{}{"list": []interface{}{process}},
+		"panels":        panels,
+	}, nil
+}
+
+// grafanaDashboardHandler serves GET /api/v1/grafana-dashboard, the
+// dashboard for the monitored targets and the selected metrics (the
+// standard ones if every metric is collected), ?datasource=influx for the
+// Influx queries.
+func grafanaDashboardHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	datasource := req.URL.Query().Get("datasource")
+	if datasource == "" {
+		datasource = "prometheus"
+	}
+	metrics := GetMetricSelection().Selected
+	if len(metrics) == 0 {
+		metrics = standardMetrics
+	}
+	dashboard, err := GrafanaDashboard(TargetNames(), metrics, datasource)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(dashboard)
+}
+
+// RunGrafanaDashboard implements the grafana-dashboard subcommand, writing
+// a dashboard for the targets and metrics of -config or -name to stdout.
+func RunGrafanaDashboard(args []string) int {
+	fs := flag.NewFlagSet("grafana-dashboard", flag.ExitOnError)
+	names := fs.String("name", "python2", "Comma separated process names, used when -config lists none.")
+	cfgFile := fs.String("config", "", "JSON config file to take processes and metrics from.")
+	datasource := fs.String("datasource", "prometheus", "Output the dashboard queries: prometheus or influx.")
+	fs.Parse(args)
+
+	var cfg Config
+	if *cfgFile != "" {
+		var err error
+		cfg, err = LoadConfig(*cfgFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	var targets []string
+	for _, p := range cfg.Processes {
+		targets = append(targets, p.Match)
+	}
+	if len(targets) == 0 {
+		targets = strings.Split(*names, ",")
+	}
+	metrics := cfg.Metrics
+	if len(metrics) == 0 {
+		metrics = standardMetrics
+	}
+	dashboard, err := GrafanaDashboard(targets, metrics, *datasource)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(dashboard)
+	return 0
+}
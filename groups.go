@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Groups of the config, e.g. {"web": ["nginx", "gunicorn"]}, are monitored
+// as the targets "group:web", whose metrics are the sums over the members.
+// A member counts as it does as a target of its own: the first matching
+// process of a name, every process of a pgid:, sid: or user: target.
+var (
+	groupsLock    sync.RWMutex
+	processGroups map[string][]string
+)
+
+func checkGroups(groups map[string][]string) error {
+	for name, members := range groups {
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("bad group name %q", name)
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("group %s has no members", name)
+		}
+		for _, m := range members {
+			if m == "" || strings.HasPrefix(m, "group:") {
+				return fmt.Errorf("group %s: bad member %q", name, m)
+			}
+		}
+	}
+	return nil
+}
+
+// groupTargets returns the targets of groups, sorted.
+func groupTargets(groups map[string][]string) []string {
+	var targets []string
+	for name := range groups {
+		targets = append(targets, "group:"+name)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+func setGroups(groups map[string][]string) {
+	groupsLock.Lock()
+	defer groupsLock.Unlock()
+	processGroups = groups
+}
+
+// groupPids returns the pids of the members of a group, none for a group
+// that isn't configured.
+func groupPids(name string) []int {
+	groupsLock.RLock()
+	members := processGroups[name]
+	groupsLock.RUnlock()
+	seen := make(map[int]bool)
+	var pids []int
+	for _, m := range members {
+		matched := ResolveTarget(m)
+		if len(matched) > 1 && !isGroupTarget(m) {
+			matched = matched[:1]
+		}
+		for _, pid := range matched {
+			if !seen[pid] {
+				seen[pid] = true
+				pids = append(pids, pid)
+			}
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressedTypes are the response content types worth compressing: the
+// JSON of the API and the text of /prometheus and the CSV export.
+var compressedTypes = []string{"application/json", "text/plain", "text/csv"}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.TrimSpace(fields[0])
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter decides on the first WriteHeader or Write, once the
+// handler has set the Content-Type, whether to compress.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		contentType := h.Get("Content-Type")
+		compress := false
+		for _, t := range compressedTypes {
+			if strings.HasPrefix(contentType, t) {
+				compress = true
+			}
+		}
+		if compress && code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", "gzip")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// compressResponses gzips the responses of h for clients that send
+// Accept-Encoding: gzip.
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(gw, req)
+		if gw.gz != nil {
+			gw.gz.Close()
+		}
+	})
+}
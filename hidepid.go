@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProcAccess describes how much of /proc the exporter can see. With the
+// hidepid mount option other users' processes are hidden (hidepid=2) or
+// unreadable (hidepid=1) unless the exporter is root, in the gid= group,
+// or reads through the reader helper.
+type ProcAccess struct {
+	Hidepid string `json:"hidepid,omitempty"`
+	Gid     int    `json:"gid,omitempty"`
+	Root    bool   `json:"root"`
+	InGroup bool   `json:"in_group"`
+	Helper  bool   `json:"helper"`
+	Limited bool   `json:"limited"`
+	Message string `json:"message"`
+}
+
+// procMountOptions returns the super block options of the proc mount at
+// procRoot from the mountinfo of the exporter, e.g. "rw,hidepid=2,gid=1001".
+func procMountOptions() string {
+	dat, err := ioutil.ReadFile(procRoot + "/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		parts := strings.SplitN(line, " - ", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) < 2 || len(fields) < 5 || fields[4] != procRoot {
+			continue
+		}
+		if super := strings.Fields(parts[1]); len(super) >= 3 && super[0] == "proc" {
+			return super[2]
+		}
+	}
+	return ""
+}
+
+func GetProcAccess() ProcAccess {
+	a := ProcAccess{Gid: -1, Root: os.Geteuid() == 0, Helper: readerSocket != ""}
+	for _, opt := range strings.Split(procMountOptions(), ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "hidepid":
+			a.Hidepid = kv[1]
+		case "gid":
+			a.Gid, _ = strconv.Atoi(kv[1])
+		}
+	}
+	if a.Gid >= 0 {
+		groups, _ := os.Getgroups()
+		for _, g := range append(groups, os.Getegid()) {
+			if g == a.Gid {
+				a.InGroup = true
+			}
+		}
+	}
+	switch {
+	case a.Hidepid == "" || a.Hidepid == "0" || a.Hidepid == "off":
+		a.Message = "all processes visible"
+	case a.Root:
+		a.Message = "hidepid=" + a.Hidepid + ", running as root"
+	case a.InGroup:
+		a.Message = "hidepid=" + a.Hidepid + ", exempt as member of gid " + strconv.Itoa(a.Gid)
+	case a.Helper:
+		a.Message = "hidepid=" + a.Hidepid + ", reading through the reader helper"
+	default:
+		a.Limited = true
+		a.Message = "hidepid=" + a.Hidepid + ": processes of other users are not visible; " +
+			"run the exporter in the gid= group of the proc mount or use -reader-socket"
+	}
+	return a
+}
+
+// healthz reports the exporter as up, with the checks that limit what it
+// can see.
+func healthz(w http.ResponseWriter, req *http.Request) {
+	access := GetProcAccess()
+	status := "ok"
+	if access.Limited {
+		status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"proc":   access,
+	})
+}
+
+// RunDoctor implements the doctor subcommand, printing what may keep the
+// exporter from seeing its targets. It exits non zero on problems.
+func RunDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	socket := fs.String("reader-socket", "", "Reader helper socket the exporter will use.")
+	root := fs.String("proc-root", procRoot, procRootUsage)
+	fs.Parse(args)
+	readerSocket = *socket
+	procRoot = *root
+
+	problems := 0
+	report := func(ok bool, format string, a ...interface{}) {
+		state := "OK  "
+		if !ok {
+			state = "FAIL"
+			problems++
+		}
+		fmt.Printf(state+" "+format+"\n", a...)
+	}
+	_, err := ioutil.ReadDir(procRoot)
+	report(err == nil, "%s readable %v", procRoot, errString(err))
+	access := GetProcAccess()
+	report(!access.Limited, "proc mount: %s", access.Message)
+	if readerSocket != "" {
+		conn, err := net.Dial("unix", readerSocket)
+		if err == nil {
+			conn.Close()
+		}
+		report(err == nil, "reader helper at %s %v", readerSocket, errString(err))
+	}
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return "(" + err.Error() + ")"
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Inventory struct {
+	Process        string       `json:"process"`
+	Pid            int          `json:"pid"`
+	Exe            string       `json:"exe,omitempty"`
+	Sha256         string       `json:"sha256,omitempty"`
+	Libraries      []string     `json:"libraries"`
+	Deleted        bool         `json:"deleted"`
+	Updated        *bool        `json:"updated"`
+	RestartPending bool         `json:"restart_pending"`
+	Runtime        *RuntimeInfo `json:"runtime,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// exeHash is the cached sha256 of one version of an executable, identified by
+// device, inode and mtime so a binary rewritten in place is hashed again.
+type exeHash struct {
+	dev, ino uint64
+	mtime    time.Time
+}
+
+var (
+	exeHashLock sync.Mutex
+	exeHashes   = make(map[exeHash]string)
+)
+
+// hashExe hashes the running image of pid through /proc/<pid>/exe, caching the
+// result per file so /inventory doesn't re-read every binary on each request.
+func hashExe(procExe string) (string, error) {
+	fi, err := os.Stat(procExe)
+	if err != nil {
+		return "", err
+	}
+	dev, ino, ok := fileID(fi)
+	key := exeHash{dev, ino, fi.ModTime()}
+	if ok {
+		exeHashLock.Lock()
+		sum, found := exeHashes[key]
+		exeHashLock.Unlock()
+		if found {
+			return sum, nil
+		}
+	}
+	sum, err := hashFile(procExe)
+	if err != nil || !ok {
+		return sum, err
+	}
+	exeHashLock.Lock()
+	if len(exeHashes) >= 1024 {
+		exeHashes = make(map[exeHash]string)
+	}
+	exeHashes[key] = sum
+	exeHashLock.Unlock()
+	return sum, nil
+}
+
+// exeInRoot is exe as seen from the mount namespace of pid, so binaries of
+// processes in containers are looked up in the container and not on the host.
+func exeInRoot(pid int, exe string) string {
+	return procRoot + "/" + strconv.Itoa(pid) + "/root" + exe
+}
+
+// exeReplaced reports whether the file at exe in the root of pid is no longer
+// the image the process is running: a different file, or the same one
+// rewritten after the process started.
+func exeReplaced(pid int, exe string, started time.Time) (bool, error) {
+	running, err := os.Stat(procRoot + "/" + strconv.Itoa(pid) + "/exe")
+	if err != nil {
+		return false, err
+	}
+	onDisk, err := os.Stat(exeInRoot(pid, exe))
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(running, onDisk) || onDisk.ModTime().After(started), nil
+}
+
+// GetLibraries returns the shared objects mapped into the process, taken
+// from the pathname column of /proc/<pid>/maps.
+func GetLibraries(pid int) ([]string, error) {
+	dat, err := readProcFile(procRoot + "/" + strconv.Itoa(pid) + "/maps")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	libs := []string{}
+	for _, line := range strings.Split(string(dat), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+		path := strings.Join(fields[5:], " ")
+		if !strings.Contains(path, ".so") || seen[path] {
+			continue
+		}
+		seen[path] = true
+		libs = append(libs, path)
+	}
+	sort.Strings(libs)
+	return libs, nil
+}
+
+// GetExeState reports whether the executable of pid has been deleted, or
+// replaced on disk after the process started, i.e. it needs a restart to
+// pick up the new binary. The file is looked up in the root of pid, and an
+// error means the state is unknown, e.g. that root can't be entered.
+func GetExeState(pid int, started time.Time) (deleted bool, replaced bool, err error) {
+	exe, err := readProcLink(procRoot + "/" + strconv.Itoa(pid) + "/exe")
+	if err != nil {
+		return false, false, err
+	}
+	if strings.HasSuffix(exe, " (deleted)") {
+		return true, false, nil
+	}
+	replaced, err = exeReplaced(pid, exe, started)
+	if os.IsNotExist(err) {
+		// gone from a root we can see into, rather than hidden by one we can't
+		if _, rerr := os.Stat(procRoot + "/" + strconv.Itoa(pid) + "/root"); rerr == nil {
+			return true, false, nil
+		}
+	}
+	return false, replaced, err
+}
+
+func GetInventory(processName string) Inventory {
+	inv := Inventory{Process: processName, Libraries: []string{}}
+	pids := ResolveTarget(processName)
+	if len(pids) == 0 {
+		inv.Error = "process not running"
+		return inv
+	}
+	inv.Pid = pids[0]
+	procExe := procRoot + "/" + strconv.Itoa(inv.Pid) + "/exe"
+	exe, err := readProcLink(procExe)
+	if err != nil {
+		inv.Error = err.Error()
+		return inv
+	}
+	if strings.HasSuffix(exe, " (deleted)") {
+		inv.Deleted = true
+		exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	inv.Exe = exe
+
+	// /proc/<pid>/exe still refers to the running image even if the file
+	// on disk has since been replaced or removed.
+	inv.Sha256, err = hashExe(procExe)
+	if err != nil {
+		inv.Error = err.Error()
+		return inv
+	}
+	if m, err := GetPidStats(inv.Pid); err == nil {
+		deleted, replaced, err := GetExeState(inv.Pid, GetStartTime(atoi64(m["starttime"])))
+		if err == nil {
+			inv.Deleted = inv.Deleted || deleted
+			inv.Updated = &replaced
+		}
+	}
+	inv.RestartPending = inv.Deleted || (inv.Updated != nil && *inv.Updated)
+
+	inv.Runtime = DetectRuntime(inv.Pid)
+
+	inv.Libraries, err = GetLibraries(inv.Pid)
+	if err != nil {
+		inv.Error = err.Error()
+		inv.Libraries = []string{}
+	}
+	return inv
+}
@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeExe makes a proc tree whose pid 100 runs bin/app of a container rooted
+// at ctr, with the binary dated before the process started.
+func fakeExe(t *testing.T) (root, ctr string) {
+	dir := t.TempDir()
+	root, ctr = filepath.Join(dir, "proc"), filepath.Join(dir, "ctr")
+	os.MkdirAll(filepath.Join(root, "100"), 0755)
+	os.MkdirAll(filepath.Join(ctr, "bin"), 0755)
+	for _, path := range []string{"stat", "100/stat"} {
+		dat, err := ioutil.ReadFile(filepath.Join("testdata/proc", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, path), dat, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := filepath.Join(ctr, "bin", "app")
+	if err := ioutil.WriteFile(app, []byte("v1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Unix(1600000000, 0)
+	os.Chtimes(app, old, old)
+	os.Symlink(app, filepath.Join(root, "100", "exe"))
+	os.Symlink(ctr, filepath.Join(root, "100", "root"))
+
+	oldRoot, oldBoot := procRoot, bootTime
+	procRoot, bootTime = root, 0
+	t.Cleanup(func() { procRoot, bootTime = oldRoot, oldBoot })
+	return root, ctr
+}
+
+var started = time.Unix(1700000050, 0)
+
+func TestExeReplaced(t *testing.T) {
+	root, ctr := fakeExe(t)
+	// the exe as the process sees it, inside its root; on the host there
+	// is nothing at /bin/app
+	if replaced, err := exeReplaced(100, "/bin/app", started); err != nil || replaced {
+		t.Errorf("unchanged binary: replaced %v, %v", replaced, err)
+	}
+
+	next := filepath.Join(ctr, "bin", "app.new")
+	ioutil.WriteFile(next, []byte("v2"), 0755)
+	os.Rename(next, filepath.Join(ctr, "bin", "app"))
+	os.Remove(filepath.Join(root, "100", "exe"))
+	os.Symlink(filepath.Join(root, "old-app"), filepath.Join(root, "100", "exe"))
+	ioutil.WriteFile(filepath.Join(root, "old-app"), []byte("v1"), 0755)
+	if replaced, err := exeReplaced(100, "/bin/app", started); err != nil || !replaced {
+		t.Errorf("replaced binary: replaced %v, %v", replaced, err)
+	}
+}
+
+func TestGetExeState(t *testing.T) {
+	root, _ := fakeExe(t)
+	setRoot := func(dir string) {
+		os.Remove(filepath.Join(root, "100", "root"))
+		if dir != "" {
+			os.Symlink(dir, filepath.Join(root, "100", "root"))
+		}
+	}
+	// a process in the host's namespace, so the link resolves as is
+	setRoot("/")
+	deleted, replaced, err := GetExeState(100, started)
+	if err != nil || deleted || replaced {
+		t.Errorf("unchanged binary: deleted %v, replaced %v, %v", deleted, replaced, err)
+	}
+
+	// nothing at the path in a root we can read
+	setRoot(t.TempDir())
+	deleted, replaced, err = GetExeState(100, started)
+	if err != nil || !deleted || replaced {
+		t.Errorf("binary gone from its root: deleted %v, replaced %v, %v", deleted, replaced, err)
+	}
+
+	setRoot("")
+	if deleted, _, err := GetExeState(100, started); err == nil {
+		t.Errorf("binary behind an inaccessible root reported as deleted %v", deleted)
+	}
+}
+
+func TestHashExeCached(t *testing.T) {
+	root, _ := fakeExe(t)
+	exe := filepath.Join(root, "100", "exe")
+	first, err := hashExe(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hashFile(exe)
+	if first != want {
+		t.Fatalf("hashExe = %s, want %s", first, want)
+	}
+	fi, _ := os.Stat(exe)
+	dev, ino, _ := fileID(fi)
+	exeHashLock.Lock()
+	exeHashes[exeHash{dev, ino, fi.ModTime()}] = "cached"
+	exeHashLock.Unlock()
+	if got, _ := hashExe(exe); got != "cached" {
+		t.Errorf("hashExe re-read the binary, got %s", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"os"
+	"strings"
+)
+
+// ioFields are the counters of /proc/<pid>/io by metric name. read_bytes
+// and write_bytes are what reached the storage layer, cancelled_write_bytes
+// what was dirtied but truncated or overwritten before reaching it; the
+// others, reported with "io_detail": true, count read and write calls,
+// including those served from the page cache.
+var ioFields = map[string]string{
+	"read_bytes":            "read_bytes",
+	"write_bytes":           "write_bytes",
+	"cancelled_write_bytes": "cancelled_write_bytes",
+	"io_rchar":              "rchar",
+	"io_wchar":              "wchar",
+	"io_syscr":              "syscr",
+	"io_syscw":              "syscw",
+}
+
+var ioDetailEnabled bool
+
+// io needs the same uid or CAP_SYS_PTRACE, like fd.
+func init() {
+	for name, field := range ioFields {
+		name, field := name, field
+		optional := strings.HasPrefix(name, "io_")
+		procmon.Register(procmon.Metric{MetricName: name, Files: []string{"io"}, Counter: true, Read: func(pid int) (int64, error) {
+			if optional && !ioDetailEnabled {
+				return 0, procmon.ErrUnavailable
+			}
+			m, err := pidIO(pid)
+			if err != nil {
+				return 0, err
+			}
+			v, ok := m[field]
+			if !ok {
+				return 0, procmon.ErrUnavailable
+			}
+			return v, nil
+		}})
+	}
+}
+
+// pidIO returns the counters of /proc/<pid>/io by name.
+func pidIO(pid int) (map[string]int64, error) {
+	v, err := cached(pid, "io", func() (interface{}, error) {
+		dat, err := pidFile(pid, "io")
+		if os.IsNotExist(err) {
+			// kernel without CONFIG_TASK_IO_ACCOUNTING
+			return nil, procmon.ErrUnavailable
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]int64)
+		for _, line := range strings.Split(string(dat), "\n") {
+			if i := strings.Index(line, ":"); i > 0 {
+				m[line[:i]] = atoi64(strings.TrimSpace(line[i+1:]))
+			}
+		}
+		return m, nil
+	})
+	m, _ := v.(map[string]int64)
+	return m, err
+}
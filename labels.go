@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProcessConfig is an entry of "processes": a target name, or
+//
+//	{"match": "nginx", "labels": {"team": "web", "env": "prod"}}
+//
+// to add static labels to every output of the target.
+type ProcessConfig struct {
+	Match  string            `json:"match"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type plainProcessConfig ProcessConfig
+
+func (p *ProcessConfig) UnmarshalJSON(dat []byte) error {
+	if len(dat) > 0 && dat[0] == '"' {
+		*p = ProcessConfig{}
+		return json.Unmarshal(dat, &p.Match)
+	}
+	return json.Unmarshal(dat, (*plainProcessConfig)(p))
+}
+
+// MarshalJSON writes targets without labels as plain names.
+func (p ProcessConfig) MarshalJSON() ([]byte, error) {
+	if len(p.Labels) == 0 {
+		return json.Marshal(p.Match)
+	}
+	return json.Marshal(plainProcessConfig(p))
+}
+
+var (
+	labelsLock   sync.RWMutex
+	targetLabels map[string]map[string]string
+)
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by the exporter itself.
+var reservedLabels = map[string]bool{"process": true, "host": true, "pid": true}
+
+func checkLabels(p ProcessConfig) error {
+	if p.Match == "" {
+		return fmt.Errorf("process without match: %v", p.Labels)
+	}
+	for k := range p.Labels {
+		if !labelNameRE.MatchString(k) || strings.HasPrefix(k, "__") || reservedLabels[k] {
+			return fmt.Errorf("%s: bad label name %q", p.Match, k)
+		}
+	}
+	return nil
+}
+
+func setTargetLabels(processes []ProcessConfig) {
+	labels := make(map[string]map[string]string)
+	for _, p := range processes {
+		if len(p.Labels) > 0 {
+			labels[p.Match] = p.Labels
+		}
+	}
+	labelsLock.Lock()
+	targetLabels = labels
+	labelsLock.Unlock()
+}
+
+// TargetLabels returns the static labels of a target, nil if it has none.
+func TargetLabels(name string) map[string]string {
+	labelsLock.RLock()
+	defer labelsLock.RUnlock()
+	return targetLabels[name]
+}
+
+// promTargetLabels returns the Prometheus labels of a target, e.g.
+// process="nginx",host="web1",env="prod",team="web". Local targets have the
+// host label too, so they match the series federated from other exporters.
+func promTargetLabels(name string) string {
+	if target, host, ok := federatedTarget(name); ok {
+		return fmt.Sprintf("process=\"%s\",host=\"%s\"", promLabelEscaper.Replace(target), promLabelEscaper.Replace(host))
+	}
+	labels := TargetLabels(name)
+	b := fmt.Sprintf("process=\"%s\",host=\"%s\"", promLabelEscaper.Replace(name), promLabelEscaper.Replace(hostname))
+	for _, k := range labelNames(labels) {
+		b += fmt.Sprintf(",%s=\"%s\"", k, promLabelEscaper.Replace(labels[k]))
+	}
+	return b
+}
+
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Hook runs Command and/or posts to Webhook when a target appears,
+// disappears or restarts. An empty Event or Process matches all.
+type Hook struct {
+	Event   string   `json:"event,omitempty"`
+	Process string   `json:"process,omitempty"`
+	Command []string `json:"command,omitempty"`
+	Webhook string   `json:"webhook,omitempty"`
+}
+
+type LifecycleEvent struct {
+	Event   string    `json:"event"`
+	Process string    `json:"process"`
+	Pid     int       `json:"pid,omitempty"`
+	OldPid  int       `json:"old_pid,omitempty"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	// Error is set for "error" events, which don't run hooks
+	Error string `json:"error,omitempty"`
+}
+
+const hookTimeout = 30 * time.Second
+
+var hooks []Hook
+
+func checkHook(h Hook) error {
+	switch h.Event {
+	case "", "appear", "disappear", "restart":
+	default:
+		return fmt.Errorf("hook: unknown event %q", h.Event)
+	}
+	if len(h.Command) == 0 && h.Webhook == "" {
+		return fmt.Errorf("hook: command or webhook required")
+	}
+	return nil
+}
+
+func firstPid(pids []int) int {
+	if len(pids) == 0 {
+		return 0
+	}
+	return pids[0]
+}
+
+// lifecycleEvent compares the first pid of a target before and after a
+// collection. Only plain targets restart, a group just changes members.
+func lifecycleEvent(processName string, oldPid int, oldStart time.Time, ps *ProcessStats) string {
+	newPid := firstPid(ps.Pids)
+	switch {
+	case oldPid == 0 && newPid != 0:
+		return "appear"
+	case oldPid != 0 && newPid == 0:
+		return "disappear"
+	case oldPid != 0 && !isGroupTarget(processName) && (oldPid != newPid || !oldStart.Equal(ps.StartTime)):
+		return "restart"
+	}
+	return ""
+}
+
+// runHooks is called under statsLock and starts the matching hooks in the
+// background.
+func runHooks(e LifecycleEvent) {
+	fmt.Println("target", e.Process, e.Event, "pid:", e.Pid)
+	for _, h := range hooks {
+		if (h.Event != "" && h.Event != e.Event) || (h.Process != "" && h.Process != e.Process) {
+			continue
+		}
+		go runHook(h, e)
+	}
+}
+
+func runHook(h Hook, e LifecycleEvent) {
+	if h.Webhook != "" {
+		if err := postJSON(h.Webhook, e); err != nil {
+			fmt.Println("hook:", err)
+		}
+	}
+	if len(h.Command) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"PROCMON_EVENT="+e.Event,
+		"PROCMON_PROCESS="+e.Process,
+		"PROCMON_PID="+strconv.Itoa(e.Pid),
+		"PROCMON_OLD_PID="+strconv.Itoa(e.OldPid),
+		"PROCMON_HOST="+e.Host,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		fmt.Println("hook", h.Command[0]+":", err, string(out))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"strings"
+)
+
+// The open files rlimit, which gives the fds count a meaning.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "fds_limit", Files: []string{"limits"}, Read: GetFdsLimit})
+	procmon.Register(procmon.Metric{MetricName: "fds_pct_used", Files: []string{"limits", "fd/"}, Read: func(pid int) (int64, error) {
+		limit, err := GetFdsLimit(pid)
+		if err != nil {
+			return 0, err
+		}
+		m, err := cached(pid, "fdinfo", func() (interface{}, error) {
+			return GetFdinfoStats(pid)
+		})
+		if err != nil {
+			return 0, err
+		}
+		return m.(map[string]int64)["fds"] * 100 / limit, nil
+	}})
+}
+
+// GetFdsLimit returns the soft "Max open files" limit of pid.
+func GetFdsLimit(pid int) (int64, error) {
+	dat, err := pidFile(pid, "limits")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		s := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(s) == 0 || s[0] == "unlimited" || atoi64(s[0]) <= 0 {
+			return 0, procmon.ErrUnavailable
+		}
+		return atoi64(s[0]), nil
+	}
+	return 0, procmon.ErrUnavailable
+}
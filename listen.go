@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// parseListenAddress splits a -listen entry into a network and address.
+// "[::]:8090" and ":8090" are dual-stack, "tcp4:0.0.0.0:8090" is IPv4 only,
+// "tcp6:[::]:8090" IPv6 only, and a zone binds a link-local address on one
+// interface: "[fe80::1%eth0]:8090". "unix:/run/proc-exporter.sock" is a
+// unix socket, see unixSocketOptions.
+func parseListenAddress(s string) (string, string) {
+	if strings.HasPrefix(s, "unix:") {
+		return "unix", strings.TrimPrefix(s, "unix:")
+	}
+	for _, network := range []string{"tcp4", "tcp6", "tcp"} {
+		if strings.HasPrefix(s, network+":") && !strings.HasPrefix(s, network+"::") {
+			return network, strings.TrimPrefix(s, network+":")
+		}
+	}
+	return "tcp", s
+}
+
+// Listen opens a listener for every comma separated address, closing the
+// ones already opened if any fails.
+func Listen(addresses string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, s := range strings.Split(addresses, ",") {
+		l, err := listen(strings.TrimSpace(s))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listen(s string) (net.Listener, error) {
+	network, address := parseListenAddress(s)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	path, mode, gid, err := unixSocketOptions(address)
+	if err != nil {
+		return nil, err
+	}
+	removeStaleSocket(path)
+	l, err := net.Listen(network, path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		err = os.Chmod(path, mode)
+	}
+	if err == nil && gid >= 0 {
+		err = os.Chown(path, -1, gid)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// unixSocketOptions splits the options off a unix socket address:
+// "/run/proc-exporter.sock?mode=0660&group=www-data" makes the socket
+// connectable by that group only, e.g. a reverse proxy, whatever the umask
+// and -run-as. mode is 0 and gid -1 when not given.
+func unixSocketOptions(address string) (path string, mode os.FileMode, gid int, err error) {
+	i := strings.IndexByte(address, '?')
+	if i < 0 {
+		return address, 0, -1, nil
+	}
+	path, gid = address[:i], -1
+	q, err := url.ParseQuery(address[i+1:])
+	if err != nil {
+		return "", 0, -1, fmt.Errorf("unix:%s: %v", address, err)
+	}
+	for k := range q {
+		if k != "mode" && k != "group" {
+			return "", 0, -1, fmt.Errorf("unix:%s: unknown option %q", address, k)
+		}
+	}
+	if s := q.Get("mode"); s != "" {
+		m, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || m == 0 || m > 0777 {
+			return "", 0, -1, fmt.Errorf("unix:%s: bad mode %q", address, s)
+		}
+		mode = os.FileMode(m)
+	}
+	if s := q.Get("group"); s != "" {
+		if gid, err = lookupGid(s); err != nil {
+			return "", 0, -1, fmt.Errorf("unix:%s: %v", address, err)
+		}
+	}
+	return path, mode, gid, nil
+}
+
+// removeStaleSocket removes the socket left behind by an exporter that
+// didn't shut down cleanly, but nothing else: a socket something still
+// listens on accepts the connection, and listening on it then fails.
+func removeStaleSocket(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		os.Remove(path)
+	}
+}
+
+// listenFdsStart is the first fd passed by systemd, see sd_listen_fds(3).
+const listenFdsStart = 3
+
+// ActivationListeners returns the sockets passed by systemd socket
+// activation, none if the exporter wasn't started that way. The variables
+// are unset so that hooks don't inherit them.
+func ActivationListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("bad LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	var fds []int
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		fds = append(fds, fd)
+	}
+	return fdListeners(fds, "socket activation")
+}
+
+// listenFdsEnv passes the listeners to the exporter re-executed by -run-as,
+// see dropPrivileges.
+const listenFdsEnv = "PROC_EXPORTER_LISTEN_FDS"
+
+// inheritedListeners returns the listeners opened before -run-as dropped
+// privileges, none if the exporter wasn't re-executed.
+func inheritedListeners() ([]net.Listener, error) {
+	defer os.Unsetenv(listenFdsEnv)
+	if os.Getenv(listenFdsEnv) == "" {
+		return nil, nil
+	}
+	var fds []int
+	for _, s := range strings.Split(os.Getenv(listenFdsEnv), ",") {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s %q", listenFdsEnv, os.Getenv(listenFdsEnv))
+		}
+		fds = append(fds, fd)
+	}
+	return fdListeners(fds, "inherited")
+}
+
+func fdListeners(fds []int, what string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, fd := range fds {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// a duplicate with close-on-exec set
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("%s fd %d: %v", what, fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// OpenListeners returns the listeners passed on by -run-as or by systemd
+// socket activation, or else listens on the -listen addresses.
+func OpenListeners(addresses string) ([]net.Listener, error) {
+	listeners, err := inheritedListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+	listeners, err = ActivationListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+	return Listen(addresses)
+}
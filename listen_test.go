@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exporter.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeStaleSocket(path)
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("removed the socket of a running listener: %v", err)
+	}
+	// as left behind by an exporter that was killed
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	removeStaleSocket(path)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	removeStaleSocket(file)
+	if _, err := os.Lstat(file); err != nil {
+		t.Fatalf("removed a regular file: %v", err)
+	}
+}
+
+func TestUnixSocketOptions(t *testing.T) {
+	tests := []struct {
+		address string
+		path    string
+		mode    os.FileMode
+		gid     int
+		bad     bool
+	}{
+		{"/run/a.sock", "/run/a.sock", 0, -1, false},
+		{"/run/a.sock?mode=0660", "/run/a.sock", 0660, -1, false},
+		{"/run/a.sock?mode=660&group=0", "/run/a.sock", 0660, 0, false},
+		{"/run/a.sock?mode=0999", "", 0, -1, true},
+		{"/run/a.sock?owner=root", "", 0, -1, true},
+		{"/run/a.sock?group=no-such-group-here", "", 0, -1, true},
+	}
+	for _, tt := range tests {
+		path, mode, gid, err := unixSocketOptions(tt.address)
+		if (err != nil) != tt.bad || path != tt.path || mode != tt.mode || gid != tt.gid {
+			t.Errorf("unixSocketOptions(%q) = %q, %o, %d, %v", tt.address, path, mode, gid, err)
+		}
+	}
+}
+
+func TestListenUnixMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporter.sock")
+	gid := os.Getgid()
+	ls, err := Listen("unix:" + path + "?mode=0660&group=" + strconv.Itoa(gid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls[0].Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0660 {
+		t.Errorf("mode %o, want 0660", fi.Mode().Perm())
+	}
+	if st := fi.Sys().(*syscall.Stat_t); int(st.Gid) != gid {
+		t.Errorf("group %d, want %d", st.Gid, gid)
+	}
+}
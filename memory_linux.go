@@ -0,0 +1,18 @@
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+// High water marks from status, so a spike between two samples still
+// shows: vm_hwm is the peak rss and vm_peak the peak vsize since the
+// process started. swap is the memory swapped out, not counted in rss.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "vm_hwm", Files: []string{"status"}, Read: func(pid int) (int64, error) {
+		return statusValue(pid, statusVmHWM)
+	}})
+	procmon.Register(procmon.Metric{MetricName: "vm_peak", Files: []string{"status"}, Read: func(pid int) (int64, error) {
+		return statusValue(pid, statusVmPeak)
+	}})
+	procmon.Register(procmon.Metric{MetricName: "swap", Files: []string{"status"}, Read: func(pid int) (int64, error) {
+		return statusValue(pid, statusVmSwap)
+	}})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"os"
+	"strings"
+)
+
+// The metrics read from stat and statm, and the state of the executable.
+// The built-in ones of procmon are replaced to read through pidcache.go.
+func init() {
+	procmon.Replace(procmon.Metric{MetricName: "cpu", Files: []string{"stat"}, Counter: true, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		return s.Int(13) + s.Int(14), nil
+	}})
+	// delayacct_blkio_ticks, which only grows with delay accounting on
+	procmon.Register(procmon.Metric{MetricName: "blkio_delay", Files: []string{"stat"}, Counter: true, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		if s.Len() < 42 {
+			return 0, procmon.ErrUnavailable
+		}
+		return s.Int(41), nil
+	}})
+	procmon.Register(procmon.Metric{MetricName: "priority", Files: []string{"stat"}, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		return s.Int(17), nil
+	}})
+	procmon.Register(procmon.Metric{MetricName: "nice", Files: []string{"stat"}, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		return s.Int(18), nil
+	}})
+	procmon.Register(procmon.Metric{MetricName: "state", Files: []string{"stat"}, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		state := s.Bytes(2)
+		if len(state) != 1 {
+			return 0, nil
+		}
+		return int64(strings.IndexByte(procStateOrder, state[0]) + 1), nil
+	}})
+	// drops to 0 when the process restarts
+	procmon.Register(procmon.Metric{MetricName: "uptime_seconds", Files: []string{"stat"}, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		uptime, err := GetSystemUptime()
+		if err != nil {
+			return 0, err
+		}
+		return int64(uptime) - s.Int(21)/procmon.ClkTck, nil
+	}})
+	procmon.Replace(procmon.Metric{MetricName: "threads", Files: []string{"stat"}, Read: func(pid int) (int64, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return 0, err
+		}
+		if n := s.Int(19); n > 0 {
+			return n, nil
+		}
+		// some /proc implementations, e.g. of sandboxes, leave it 0 in stat
+		return statusValue(pid, statusThreads)
+	}})
+	procmon.Replace(procmon.Metric{MetricName: "rss", Files: []string{"statm"}, Read: func(pid int) (int64, error) {
+		s, err := pidStatm(pid)
+		if err != nil {
+			return 0, err
+		}
+		return s.Int(1) * pageSize, nil
+	}})
+	procmon.Replace(procmon.Metric{MetricName: "vsize", Files: []string{"statm"}, Read: func(pid int) (int64, error) {
+		s, err := pidStatm(pid)
+		if err != nil {
+			return 0, err
+		}
+		return s.Int(0) * pageSize, nil
+	}})
+	procmon.Register(procmon.Metric{MetricName: "exe_deleted", Files: []string{"stat", "exe"}, Read: func(pid int) (int64, error) {
+		deleted, _, err := exeState(pid)
+		return boolToInt(deleted), err
+	}})
+	procmon.Register(procmon.Metric{MetricName: "restart_pending", Files: []string{"stat", "exe"}, Read: func(pid int) (int64, error) {
+		deleted, replaced, err := exeState(pid)
+		return boolToInt(deleted || replaced), err
+	}})
+}
+
+type exeStateResult struct {
+	deleted, replaced bool
+}
+
+func exeState(pid int) (deleted bool, replaced bool, err error) {
+	v, err := cached(pid, "exe", func() (interface{}, error) {
+		s, err := pidStat(pid)
+		if err != nil {
+			return nil, err
+		}
+		deleted, replaced, err := GetExeState(pid, GetStartTime(s.Int(21)))
+		if errors.Is(err, os.ErrPermission) {
+			return nil, err
+		} else if err != nil {
+			// kernel threads have no exe, and a root that is gone
+			// leaves the state unknown
+			return nil, procmon.ErrUnavailable
+		}
+		return exeStateResult{deleted, replaced}, nil
+	})
+	if err != nil {
+		return false, false, err
+	}
+	r := v.(exeStateResult)
+	return r.deleted, r.replaced, nil
+}
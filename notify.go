@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Notifier interface {
+	Notify(e AlertEvent) error
+}
+
+var notifiers []Notifier
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// WebhookNotifier posts the AlertEvent as JSON, or when Fields is set a
+// JSON object with each field rendered from its template. In the config it
+// is either a plain URL string or an object.
+type WebhookNotifier struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (n *WebhookNotifier) UnmarshalJSON(b []byte) error {
+	var url string
+	if err := json.Unmarshal(b, &url); err == nil {
+		n.URL = url
+		return nil
+	}
+	type plain WebhookNotifier
+	return json.Unmarshal(b, (*plain)(n))
+}
+
+func (n WebhookNotifier) Notify(e AlertEvent) error {
+	if len(n.Fields) == 0 {
+		return postJSON(n.URL, e)
+	}
+	payload := make(map[string]string)
+	for name, text := range n.Fields {
+		v, err := executeTemplate(name, text, e)
+		if err != nil {
+			return err
+		}
+		payload[name] = v
+	}
+	return postJSON(n.URL, payload)
+}
+
+type SlackNotifier struct {
+	URL     string `json:"url"`
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+}
+
+var byteMetrics = map[string]bool{
+	"rss":                 true,
+	"vsize":               true,
+	"vm_hwm":              true,
+	"vm_peak":             true,
+	"swap":                true,
+	"smaps_anon":          true,
+	"smaps_file":          true,
+	"smaps_shared":        true,
+	"smaps_locked":        true,
+	"cgroup_memory_usage": true,
+	"cgroup_memory_limit": true,
+}
+
+func isByteMetric(metric string) bool {
+	return byteMetrics[metric] || strings.HasPrefix(metric, "numa_node_")
+}
+
+func formatBytes(v int64) string {
+	const unit = 1024
+	if v < unit && v > -unit {
+		return fmt.Sprintf("%dB", v)
+	}
+	div, exp := int64(unit), 0
+	for n := v / unit; n >= unit || n <= -unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(v)/float64(div), "KMGTPE"[exp])
+}
+
+func formatValue(metric string, v int64) string {
+	if isByteMetric(metric) {
+		return formatBytes(v)
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+func SlackMessage(e AlertEvent) string {
+	icon := ":red_circle:"
+	if e.Status == "resolved" {
+		icon = ":large_green_circle:"
+	}
+	return fmt.Sprintf("%s *[%s]* `%s` (pid %d)\n*Metric:* %s\n*Current value:* %s\n*Threshold:* %s %s for %s\n*Duration:* %s",
+		icon, strings.ToUpper(e.Status),
+		e.Process, e.Pid, e.Metric,
+		formatValue(e.Metric, e.Value),
+		e.Op, formatValue(e.Metric, e.Threshold), e.For,
+		e.Time.Sub(e.Since).Round(time.Second))
+}
+
+func (n SlackNotifier) Notify(e AlertEvent) error {
+	text := SlackMessage(e)
+	if n.Text != "" {
+		var err error
+		text, err = executeTemplate("text", n.Text, e)
+		if err != nil {
+			return err
+		}
+	}
+	msg := map[string]string{"text": text}
+	if n.Channel != "" {
+		msg["channel"] = n.Channel
+	}
+	return postJSON(n.URL, msg)
+}
+
+type EmailNotifier struct {
+	Server   string   `json:"server"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Subject  string   `json:"subject"`
+	Body     string   `json:"body"`
+}
+
+const defaultEmailSubject = `[{{upper .Status}}] {{.Process}} {{.Metric}} {{.Op}} {{value .Metric .Threshold}}`
+
+const defaultEmailBody = `Alert {{.Status}} for {{.Process}} (pid {{.Pid}})
+
+Rule:          {{.Rule}}
+Current value: {{value .Metric .Value}}
+Threshold:     {{.Op}} {{value .Metric .Threshold}} for {{.For}}
+Since:         {{.Since}}
+`
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"value": formatValue,
+}
+
+func executeTemplate(name string, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	return b.String(), err
+}
+
+// CheckTemplates parses every template a notifier is configured with so
+// mistakes are reported at startup rather than when an alert fires.
+func CheckTemplates(n Notifier) error {
+	var texts []string
+	switch n := n.(type) {
+	case WebhookNotifier:
+		for _, t := range n.Fields {
+			texts = append(texts, t)
+		}
+	case SlackNotifier:
+		texts = append(texts, n.Text)
+	case EmailNotifier:
+		texts = append(texts, n.Subject, n.Body)
+	}
+	for _, text := range texts {
+		if _, err := template.New("check").Funcs(templateFuncs).Parse(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subjectLineBreaks are replaced in rendered subjects, where a target name
+// or alert text could otherwise add headers.
+var subjectLineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// encodeSubject makes a rendered subject a single header line, Q-encoded
+// when it isn't plain ASCII.
+func encodeSubject(subject string) string {
+	return mime.QEncoding.Encode("utf-8", subjectLineBreaks.Replace(subject))
+}
+
+func (n EmailNotifier) Notify(e AlertEvent) error {
+	subjectText, bodyText := n.Subject, n.Body
+	if subjectText == "" {
+		subjectText = defaultEmailSubject
+	}
+	if bodyText == "" {
+		bodyText = defaultEmailBody
+	}
+	subject, err := executeTemplate("subject", subjectText, e)
+	if err != nil {
+		return err
+	}
+	body, err := executeTemplate("body", bodyText, e)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	msg := "From: " + n.From + "\r\n" +
+		"To: " + strings.Join(n.To, ", ") + "\r\n" +
+		"Subject: " + encodeSubject(subject) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+	return smtp.SendMail(n.Server, auth, n.From, n.To, []byte(msg))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"[FIRING] nginx rss", "[FIRING] nginx rss"},
+		{"nginx\r\nBcc: victim@example.com", "nginx Bcc: victim@example.com"},
+		{"nginx\rBcc: a@example.com", "nginx Bcc: a@example.com"},
+		{"nginx\nBcc: a@example.com", "nginx Bcc: a@example.com"},
+		{"café rss", "=?utf-8?q?caf=C3=A9_rss?="},
+	}
+	for _, tt := range tests {
+		got := encodeSubject(tt.subject)
+		if got != tt.want {
+			t.Errorf("encodeSubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("encodeSubject(%q) has a line break", tt.subject)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// numaEnabled turns on the per node breakdown, which reads numa_maps, one
+// line per mapping, each interval.
+var numaEnabled bool
+
+// GetNumaStats sums the resident pages of pid on each NUMA node from
+// numa_maps, returning "numa_node_<n>" gauges in bytes.
+func GetNumaStats(pid int) (map[string]int64, error) {
+	dat, err := pidFile(pid, "numa_maps")
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int64)
+	for _, line := range strings.Split(string(dat), "\n") {
+		fields := strings.Fields(line)
+		pageSize := int64(4096)
+		for _, f := range fields {
+			if strings.HasPrefix(f, "kernelpagesize_kB=") {
+				pageSize = atoi64(strings.TrimPrefix(f, "kernelpagesize_kB=")) * 1024
+			}
+		}
+		for _, f := range fields {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 || len(kv[0]) < 2 || kv[0][0] != 'N' {
+				continue
+			}
+			if _, err := strconv.Atoi(kv[0][1:]); err != nil {
+				continue
+			}
+			m["numa_node_"+kv[0][1:]] += atoi64(kv[1]) * pageSize
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"strings"
+)
+
+// oom_score is the badness the OOM killer gives the process, the highest
+// is killed first; oom_score_adj is the adjustment set for it, -1000 to
+// 1000.
+func init() {
+	for _, name := range []string{"oom_score", "oom_score_adj"} {
+		name := name
+		procmon.Register(procmon.Metric{MetricName: name, Files: []string{name}, Read: func(pid int) (int64, error) {
+			dat, err := pidFile(pid, name)
+			if err != nil {
+				return 0, err
+			}
+			return atoi64(strings.TrimSpace(string(dat))), nil
+		}})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// perCPUEnabled turns on the per core breakdown, which reads every
+// thread's stat file each interval.
+var perCPUEnabled bool
+
+type threadTicks struct {
+	ticks    map[int]int64
+	lastSeen time.Time
+}
+
+// perCPUTicks has the thread ticks of the previous read of every pid, which
+// the next read is relative to.
+type perCPUTicks struct {
+	lock  sync.Mutex
+	state map[int]*threadTicks
+}
+
+func newPerCPUTicks() *perCPUTicks {
+	return &perCPUTicks{state: make(map[int]*threadTicks)}
+}
+
+// parseCPUList parses a list such as "0-3,8,10-11".
+func parseCPUList(s string) []int {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		for c := lo; c <= hi; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus
+}
+
+// GetAllowedCPUs returns Cpus_allowed_list from /proc/<pid>/status.
+func GetAllowedCPUs(pid int) []int {
+	st, err := pidStatus(pid)
+	if err != nil || st.cpusAllowed == "" {
+		return nil
+	}
+	return parseCPUList(st.cpusAllowed)
+}
+
+// read attributes the cpu ticks each thread used since the previous read
+// to the cpu it last ran on (field 39 of the thread's stat), returning
+// "cpu_core_<n>" gauges for every cpu the process may run on.
+func (p *perCPUTicks) read(pid int) map[string]int64 {
+	m := make(map[string]int64)
+	for _, c := range GetAllowedCPUs(pid) {
+		m["cpu_core_"+strconv.Itoa(c)] = 0
+	}
+	taskDir := procRoot + "/" + strconv.Itoa(pid) + "/task"
+	tasks, err := ioutil.ReadDir(taskDir)
+	if err != nil {
+		return m
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	prev := p.state[pid]
+	cur := &threadTicks{ticks: make(map[int]int64), lastSeen: time.Now()}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		dat, err := ioutil.ReadFile(taskDir + "/" + t.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		s := procmon.StatFields(string(dat))
+		if len(s) < 39 {
+			continue
+		}
+		ticks := atoi64(s[13]) + atoi64(s[14])
+		cur.ticks[tid] = ticks
+		if prev == nil {
+			continue
+		}
+		if before, ok := prev.ticks[tid]; ok && ticks >= before {
+			m["cpu_core_"+s[38]] += ticks - before
+		}
+	}
+	p.state[pid] = cur
+	for other, st := range p.state {
+		if time.Since(st.lastSeen) > time.Minute {
+			delete(p.state, other)
+		}
+	}
+	return m
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type cachedRead struct {
+	v   interface{}
+	err error
+}
+
+// pidReads holds the reads shared by several metrics of a pid being read
+// by linuxPlatform.ReadStats, so that e.g. stat is read once per sample
+// rather than once per metric. Targets are collected concurrently and may
+// share pids, hence the reference count. Files are read into pooled
+// buffers that go back to the pool once the last read of the pid ends.
+type pidReads struct {
+	lock    sync.Mutex
+	refs    int
+	reads   map[string]cachedRead
+	buffers []*[]byte
+	fields  []*procFields
+}
+
+var (
+	pidCacheLock sync.Mutex
+	pidCache     = make(map[int]*pidReads)
+)
+
+// procLinks are metric sources that are symlinks rather than files.
+var procLinks = map[string]bool{"exe": true, "cwd": true, "root": true}
+
+func beginPidRead(pid int) {
+	pidCacheLock.Lock()
+	r := pidCache[pid]
+	if r != nil {
+		r.refs++
+		pidCacheLock.Unlock()
+		return
+	}
+	r = &pidReads{refs: 1, reads: make(map[string]cachedRead)}
+	pidCache[pid] = r
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pidCacheLock.Unlock()
+	if !snapshotReads {
+		return
+	}
+	// read the file sources of the enabled metrics together, see
+	// readPidFiles
+	seen := make(map[string]bool)
+	var names []string
+	for _, c := range procmon.Collectors() {
+		if !collectedMetric(c) {
+			continue
+		}
+		for _, src := range c.Sources() {
+			if !seen[src] && !strings.HasSuffix(src, "/") && !procLinks[src] && sourceEnabled(src) {
+				seen[src] = true
+				names = append(names, src)
+			}
+		}
+	}
+	files, errs := readPidFilesEach(pid, names...)
+	for i, name := range names {
+		// those that failed are read again, and fail, by the metric
+		if errs[i] == nil {
+			r.reads["file:"+name] = cachedRead{v: files[i]}
+		}
+	}
+}
+
+// sourceEnabled reports whether the metrics reading src are collected, as
+// smaps and sockets are only read when enabled in the config.
+func sourceEnabled(src string) bool {
+	switch {
+	case src == "smaps_rollup":
+		return smapsEnabled
+	case strings.HasPrefix(src, "net/"):
+		return socketsEnabled
+	}
+	return true
+}
+
+func endPidRead(pid int) {
+	pidCacheLock.Lock()
+	defer pidCacheLock.Unlock()
+	if r := pidCache[pid]; r != nil {
+		r.refs--
+		if r.refs == 0 {
+			delete(pidCache, pid)
+			for _, b := range r.buffers {
+				procBuffers.Put(b)
+			}
+			for _, f := range r.fields {
+				procFieldsPool.Put(f)
+			}
+		}
+	}
+}
+
+// pidBuffer returns a pooled buffer for a file of pid, nil outside of
+// ReadStats or with the reader helper.
+func pidBuffer(pid int) *[]byte {
+	if readerSocket != "" {
+		return nil
+	}
+	pidCacheLock.Lock()
+	r := pidCache[pid]
+	pidCacheLock.Unlock()
+	if r == nil {
+		return nil
+	}
+	b := procBuffers.Get().(*[]byte)
+	r.lock.Lock()
+	r.buffers = append(r.buffers, b)
+	r.lock.Unlock()
+	return b
+}
+
+// pidFields returns procFields released with the buffers of pid.
+func pidFields(pid int) *procFields {
+	pidCacheLock.Lock()
+	r := pidCache[pid]
+	pidCacheLock.Unlock()
+	if r == nil {
+		return new(procFields)
+	}
+	f := procFieldsPool.Get().(*procFields)
+	r.lock.Lock()
+	r.fields = append(r.fields, f)
+	r.lock.Unlock()
+	return f
+}
+
+// cached returns read(), calling it only once per key while pid is being
+// read by ReadStats.
+func cached(pid int, key string, read func() (interface{}, error)) (interface{}, error) {
+	pidCacheLock.Lock()
+	r := pidCache[pid]
+	pidCacheLock.Unlock()
+	if r == nil {
+		return read()
+	}
+	r.lock.Lock()
+	c, ok := r.reads[key]
+	r.lock.Unlock()
+	if ok {
+		return c.v, c.err
+	}
+	// not under the lock, read may call cached itself
+	v, err := read()
+	r.lock.Lock()
+	r.reads[key] = cachedRead{v, err}
+	r.lock.Unlock()
+	return v, err
+}
+
+// pidFile reads /proc/<pid>/<name>.
+func pidFile(pid int, name string) ([]byte, error) {
+	v, err := cached(pid, "file:"+name, func() (interface{}, error) {
+		if b := pidBuffer(pid); b != nil {
+			return readFileInto(procRoot+"/"+strconv.Itoa(pid)+"/"+name, b)
+		}
+		files, err := readPidFiles(pid, name)
+		if err != nil {
+			return nil, err
+		}
+		return files[0], nil
+	})
+	dat, _ := v.([]byte)
+	return dat, err
+}
+
+// pidStat returns the fields of /proc/<pid>/stat, see procmon.StatFields.
+// They are only valid during ReadStats, or until the next call outside.
+func pidStat(pid int) (*procFields, error) {
+	v, err := cached(pid, "stat:fields", func() (interface{}, error) {
+		dat, err := pidFile(pid, "stat")
+		if err != nil {
+			return nil, err
+		}
+		f := pidFields(pid)
+		f.splitStat(dat)
+		if f.Len() < 22 {
+			return nil, fmt.Errorf("%s/%d/stat: truncated", procRoot, pid)
+		}
+		return f, nil
+	})
+	f, _ := v.(*procFields)
+	return f, err
+}
+
+// pidStatm returns the fields of /proc/<pid>/statm.
+func pidStatm(pid int) (*procFields, error) {
+	v, err := cached(pid, "statm:fields", func() (interface{}, error) {
+		dat, err := pidFile(pid, "statm")
+		if err != nil {
+			return nil, err
+		}
+		f := pidFields(pid)
+		f.split(dat)
+		if f.Len() < 2 {
+			return nil, fmt.Errorf("%s/%d/statm: truncated", procRoot, pid)
+		}
+		return f, nil
+	})
+	f, _ := v.(*procFields)
+	return f, err
+}
+
+// The fields of status the metrics use.
+const (
+	statusVoluntaryCtxt = iota
+	statusNonvoluntaryCtxt
+	statusVmHWM
+	statusVmPeak
+	statusVmSwap
+	statusThreads
+	numStatusFields
+)
+
+var statusNames = map[string]int{
+	"voluntary_ctxt_switches":    statusVoluntaryCtxt,
+	"nonvoluntary_ctxt_switches": statusNonvoluntaryCtxt,
+	"VmHWM":                      statusVmHWM,
+	"VmPeak":                     statusVmPeak,
+	"VmSwap":                     statusVmSwap,
+	"Threads":                    statusThreads,
+}
+
+// statusFields are the fields of /proc/<pid>/status that metrics use,
+// taken in one pass over the file however many metrics read them. Values
+// in kB are in bytes.
+type statusFields struct {
+	values      [numStatusFields]int64
+	found       [numStatusFields]bool
+	cpusAllowed string
+}
+
+func parseStatus(dat []byte) *statusFields {
+	st := &statusFields{}
+	for len(dat) > 0 {
+		line := dat
+		if i := bytes.IndexByte(dat, '\n'); i >= 0 {
+			line, dat = dat[:i], dat[i+1:]
+		} else {
+			dat = nil
+		}
+		i := bytes.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		value := bytes.TrimSpace(line[i+1:])
+		if string(line[:i]) == "Cpus_allowed_list" {
+			st.cpusAllowed = string(value)
+			continue
+		}
+		field, ok := statusNames[string(line[:i])]
+		if !ok {
+			continue
+		}
+		if bytes.HasSuffix(value, []byte(" kB")) {
+			st.values[field] = parseInt(value[:len(value)-3]) * 1024
+		} else {
+			st.values[field] = parseInt(value)
+		}
+		st.found[field] = true
+	}
+	return st
+}
+
+// pidStatus returns the fields of /proc/<pid>/status.
+func pidStatus(pid int) (*statusFields, error) {
+	v, err := cached(pid, "status:fields", func() (interface{}, error) {
+		dat, err := pidFile(pid, "status")
+		if err != nil {
+			return nil, err
+		}
+		return parseStatus(dat), nil
+	})
+	st, _ := v.(*statusFields)
+	return st, err
+}
+
+// statusValue returns a field of status, procmon.ErrUnavailable if the
+// process has none, e.g. the memory fields of kernel threads.
+func statusValue(pid int, field int) (int64, error) {
+	st, err := pidStatus(pid)
+	if err != nil {
+		return 0, err
+	}
+	if !st.found[field] {
+		return 0, procmon.ErrUnavailable
+	}
+	return st.values[field], nil
+}
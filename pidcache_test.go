@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBeginPidReadSnapshot(t *testing.T) {
+	useTestProc(t)
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "100"), 0755)
+	for _, name := range []string{"stat", "statm", "status"} {
+		dat, err := ioutil.ReadFile(filepath.Join(procRoot, "100", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(root, "100", name), dat, 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, "100", "smaps_rollup"), []byte("Rss: 4 kB\n"), 0644)
+	procRoot = root
+	oldSnapshot, oldSmaps := snapshotReads, smapsEnabled
+	defer func() { snapshotReads, smapsEnabled = oldSnapshot, oldSmaps }()
+
+	for _, smaps := range []bool{false, true} {
+		snapshotReads, smapsEnabled = true, smaps
+		beginPidRead(100)
+		pidCacheLock.Lock()
+		r := pidCache[100]
+		pidCacheLock.Unlock()
+		// there is no io or limits, which must not lose the rest
+		for _, name := range []string{"stat", "statm", "status"} {
+			if _, ok := r.reads["file:"+name]; !ok {
+				t.Errorf("%s not read with the others", name)
+			}
+		}
+		if _, ok := r.reads["file:smaps_rollup"]; ok != smaps {
+			t.Errorf("smaps_rollup read %v with smaps enabled %v", ok, smaps)
+		}
+		endPidRead(100)
+	}
+}
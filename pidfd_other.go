@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import "syscall"
+
+func pidfdOpen(pid int) (int, error) {
+	return -1, syscall.ENOSYS
+}
+
+func pidfdSendSignal(fd int, sig syscall.Signal) error {
+	return syscall.ENOSYS
+}
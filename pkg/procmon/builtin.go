@@ -0,0 +1,69 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// The metrics every process has, cpu and threads from stat, rss and vsize
+// from statm. They are registered like any other MetricCollector; programs
+// with a faster way to read them, e.g. from a cache of the parsed files,
+// swap in their own collectors with Replace.
+func init() {
+	Register(procFileMetric{name: "cpu", file: "stat", counter: true, value: func(f []string) int64 {
+		return atoi64(f[13]) + atoi64(f[14])
+	}})
+	Register(procFileMetric{name: "threads", file: "stat", value: func(f []string) int64 {
+		return atoi64(f[19])
+	}})
+	Register(procFileMetric{name: "rss", file: "statm", value: func(f []string) int64 {
+		return atoi64(f[1]) * pageSize
+	}})
+	Register(procFileMetric{name: "vsize", file: "statm", value: func(f []string) int64 {
+		return atoi64(f[0]) * pageSize
+	}})
+}
+
+// rootCollector is implemented by the built-in collectors, which read
+// proc(5) mounted at root rather than at /proc, see ProcSource.Root.
+type rootCollector interface {
+	collectAt(root string, pid int) (int64, error)
+}
+
+// procFileMetric is a metric computed from the fields of one file of
+// /proc/<pid>, stat or statm.
+type procFileMetric struct {
+	name    string
+	file    string
+	counter bool
+	value   func(fields []string) int64
+}
+
+func (m procFileMetric) Name() string      { return m.name }
+func (m procFileMetric) Sources() []string { return []string{m.file} }
+func (m procFileMetric) Cumulative() bool  { return m.counter }
+
+func (m procFileMetric) Collect(pid int) (int64, error) {
+	return m.collectAt("/proc", pid)
+}
+
+func (m procFileMetric) collectAt(root string, pid int) (int64, error) {
+	path := root + "/" + strconv.Itoa(pid) + "/" + m.file
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var fields []string
+	min := 2
+	if m.file == "stat" {
+		fields, min = StatFields(string(dat)), 22
+	} else {
+		fields = strings.Fields(string(dat))
+	}
+	if len(fields) < min {
+		return 0, fmt.Errorf("%s: truncated", path)
+	}
+	return m.value(fields), nil
+}
@@ -0,0 +1,296 @@
+package procmon
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target is the collection state of one target: the pids it matched, the
+// counter baselines and, when collected through Collector.Collect, its
+// sample history.
+type Target struct {
+	Samples      Ring
+	Pids         []int
+	LastScrape   time.Time
+	LastDuration time.Duration
+	LastError    string
+	// StartTime is when Pids[0] started, to tell a restart from pid reuse.
+	StartTime time.Time
+	// PerPid has the metrics of each member of a group target in the last
+	// sample, if Collector.PerPid is set.
+	PerPid map[int]map[string]int64
+	// MetricErrors has the metrics missing from the last sample because
+	// they couldn't be read for some member, see PidStats.Errors.
+	MetricErrors map[string]string
+
+	prevRaw     map[int]map[string]int64
+	prevStart   map[int]time.Time
+	initialized bool
+}
+
+// Initialized reports whether the counter baselines are set, i.e. the next
+// sample has deltas.
+func (t *Target) Initialized() bool {
+	return t.initialized
+}
+
+// Baselines returns the last raw counter values per pid.
+func (t *Target) Baselines() map[int]map[string]int64 {
+	return t.prevRaw
+}
+
+func (t *Target) reset(err string) {
+	t.LastError = err
+	t.initialized = false
+	t.prevRaw = nil
+	t.prevStart = nil
+	t.PerPid = nil
+	t.MetricErrors = nil
+}
+
+// Collector collects targets from a Source. A target is a name passed to
+// Resolve, by default an executable name.
+type Collector struct {
+	Source Source
+	// Resolve returns the pids of a target, FindByName under the Root of a
+	// ProcSource, or /proc, if nil.
+	Resolve func(target string) []int
+	// Group reports whether every pid of a target is summed rather than
+	// only the first one collected. Nil collects the first pid only.
+	Group func(target string) bool
+	// Retention is the number of samples Collect keeps per target, 300 if
+	// zero.
+	Retention int
+	// PerPid keeps the metrics of every member of group targets besides
+	// their sum, see Target.PerPid.
+	PerPid bool
+
+	lock    sync.Mutex
+	targets map[string]*Target
+
+	counterLock sync.RWMutex
+	counters    map[string]bool
+}
+
+func (c *Collector) root() string {
+	switch p := c.Source.(type) {
+	case ProcSource:
+		return p.root()
+	case *ProcSource:
+		return p.root()
+	}
+	return "/proc"
+}
+
+// NewCollector returns a Collector of targets reading from source.
+func NewCollector(source Source, targets ...string) *Collector {
+	c := &Collector{Source: source}
+	for _, name := range targets {
+		c.Add(name)
+	}
+	return c
+}
+
+// Add starts collecting name from the next Collect, returning false if it is
+// already collected.
+func (c *Collector) Add(name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.targets == nil {
+		c.targets = make(map[string]*Target)
+	}
+	if c.targets[name] != nil {
+		return false
+	}
+	c.targets[name] = &Target{}
+	return true
+}
+
+// Remove stops collecting name and drops its history, returning false if it
+// was not collected.
+func (c *Collector) Remove(name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.targets[name] == nil {
+		return false
+	}
+	delete(c.targets, name)
+	return true
+}
+
+func (c *Collector) Targets() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	names := make([]string, 0, len(c.targets))
+	for name := range c.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Series returns a copy of the sample history of name.
+func (c *Collector) Series(name string) Series {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := Series{Target: name}
+	if t := c.targets[name]; t != nil {
+		s.Samples = t.Samples.Slice()
+	}
+	return s
+}
+
+// IsCounter reports whether metric has been reported as a counter, i.e. its
+// samples are per interval deltas.
+func (c *Collector) IsCounter(metric string) bool {
+	c.counterLock.RLock()
+	defer c.counterLock.RUnlock()
+	return c.counters[metric]
+}
+
+// Collect collects every target and appends the samples to their history.
+// It returns the samples of the targets that could be collected; a target
+// that can't is left out, see Target.LastError.
+func (c *Collector) Collect(ctx context.Context) (map[string]Sample, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	retention := c.Retention
+	if retention == 0 {
+		retention = 300
+	}
+	samples := make(map[string]Sample)
+	for name, t := range c.targets {
+		s, err := c.CollectTarget(ctx, name, t)
+		if ctx.Err() != nil {
+			return samples, ctx.Err()
+		}
+		if err != nil {
+			continue
+		}
+		t.Samples.Resize(retention)
+		t.Samples.Push(s)
+		samples[name] = s
+	}
+	return samples, nil
+}
+
+// CollectTarget reads the current stats of target into t and turns
+// cumulative counters into deltas against the previous collection. Group
+// targets sum every member; counters are compared per pid so members coming
+// and going don't show up as jumps. The history of t is left alone.
+func (c *Collector) CollectTarget(ctx context.Context, target string, t *Target) (Sample, error) {
+	t.LastScrape = time.Now()
+	defer func() { t.LastDuration = time.Since(t.LastScrape) }()
+	if c.Resolve != nil {
+		t.Pids = c.Resolve(target)
+	} else {
+		t.Pids = FindByName(c.root(), target)
+	}
+	if len(t.Pids) == 0 {
+		t.reset("no matching process")
+		return Sample{}, errors.New(t.LastError)
+	}
+	pids := t.Pids
+	group := c.Group != nil && c.Group(target)
+	if !group {
+		pids = pids[:1]
+	}
+	s := Sample{
+		Time:    t.LastScrape.UnixNano() / int64(time.Millisecond),
+		Pid:     pids[0],
+		Metrics: make(map[string]int64),
+	}
+	raw := make(map[int]map[string]int64)
+	starts := make(map[int]time.Time)
+	var perPid map[int]map[string]int64
+	if c.PerPid && group {
+		perPid = make(map[int]map[string]int64)
+	}
+	var lastErr error
+	var metricErrors map[string]string
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return Sample{}, err
+		}
+		start := time.Now()
+		st, err := c.Source.ReadStats(pid)
+		s.Metrics["read_duration_us"] += int64(time.Since(start) / time.Microsecond)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for name, e := range st.Errors {
+			if metricErrors == nil {
+				metricErrors = make(map[string]string)
+			}
+			metricErrors[name] = e
+		}
+		raw[pid] = st.Counters
+		starts[pid] = st.StartTime
+		if pid == pids[0] {
+			t.StartTime = st.StartTime
+		}
+		var pm map[string]int64
+		if perPid != nil {
+			pm = make(map[string]int64)
+			perPid[pid] = pm
+		}
+		for name, v := range st.Gauges {
+			s.Metrics[name] += v
+			if pm != nil {
+				pm[name] = v
+			}
+		}
+		c.addCounters(st.Counters)
+		prev, seen := t.prevRaw[pid]
+		// A reused pid or a process restarted under the same pid, e.g.
+		// as pid 1 of a container, counts from zero again.
+		seen = seen && st.StartTime.Equal(t.prevStart[pid])
+		for name, v := range st.Counters {
+			delta := int64(0)
+			// A counter going backwards for the same process can only be
+			// a reset; start again from the new value.
+			if seen && v >= prev[name] {
+				delta = v - prev[name]
+			}
+			s.Metrics[name] += delta
+			if pm != nil {
+				pm[name] = delta
+			}
+		}
+	}
+	if len(raw) == 0 {
+		t.reset(lastErr.Error())
+		return Sample{}, lastErr
+	}
+	t.LastError = ""
+	t.prevRaw = raw
+	t.prevStart = starts
+	t.PerPid = perPid
+	t.MetricErrors = metricErrors
+	t.initialized = true
+	return s, nil
+}
+
+func (c *Collector) addCounters(counters map[string]int64) {
+	c.counterLock.RLock()
+	known := true
+	for name := range counters {
+		known = known && c.counters[name]
+	}
+	c.counterLock.RUnlock()
+	if known {
+		return
+	}
+	c.counterLock.Lock()
+	if c.counters == nil {
+		c.counters = make(map[string]bool)
+	}
+	for name := range counters {
+		c.counters[name] = true
+	}
+	c.counterLock.Unlock()
+}
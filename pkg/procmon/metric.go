@@ -0,0 +1,147 @@
+package procmon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// MetricCollector reads one metric of a process.
+type MetricCollector interface {
+	Name() string
+	// Sources are the entries of /proc/<pid> the metric is read from, e.g.
+	// "stat" or "fd/" for a directory.
+	Sources() []string
+	Collect(pid int) (int64, error)
+}
+
+// Cumulative is implemented by MetricCollectors of ever increasing values,
+// which the Collector turns into per interval deltas.
+type Cumulative interface {
+	Cumulative() bool
+}
+
+// ErrUnavailable is returned, possibly wrapped, by a MetricCollector that
+// can't read its metric for this process, e.g. on an older kernel. The
+// metric is left out of the sample, as it is on a permission error. Any
+// other error fails the whole sample.
+var ErrUnavailable = errors.New("metric unavailable")
+
+// Metric is a MetricCollector calling Read.
+type Metric struct {
+	MetricName string
+	Files      []string
+	Counter    bool
+	Read       func(pid int) (int64, error)
+}
+
+func (m Metric) Name() string                   { return m.MetricName }
+func (m Metric) Sources() []string              { return m.Files }
+func (m Metric) Collect(pid int) (int64, error) { return m.Read(pid) }
+func (m Metric) Cumulative() bool               { return m.Counter }
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]MetricCollector)
+)
+
+// Register adds c to the metrics collected for every process. It panics if
+// a collector of the same name is registered already.
+func Register(c MetricCollector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if registry[c.Name()] != nil {
+		panic(fmt.Sprintf("procmon: metric %s registered twice", c.Name()))
+	}
+	registry[c.Name()] = c
+}
+
+// Replace registers c in place of the collector of the same name, e.g. to
+// read a built-in metric through a cache. It panics if there is none.
+func Replace(c MetricCollector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if registry[c.Name()] == nil {
+		panic(fmt.Sprintf("procmon: metric %s is not registered", c.Name()))
+	}
+	registry[c.Name()] = c
+}
+
+// Collectors returns the registered collectors sorted by name.
+func Collectors() []MetricCollector {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	result := make([]MetricCollector, 0, len(registry))
+	for _, c := range registry {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result
+}
+
+// errorState names err for PidStats.Errors, e.g. "EACCES".
+func errorState(err error) string {
+	switch {
+	case errors.Is(err, syscall.EACCES):
+		return "EACCES"
+	case errors.Is(err, syscall.EPERM):
+		return "EPERM"
+	}
+	return err.Error()
+}
+
+// CollectRegistered adds every registered metric of pid to st. ProcSource
+// does this itself; other Sources call it to support registered collectors.
+func CollectRegistered(pid int, st *PidStats) error {
+	return CollectSelected(pid, st, nil)
+}
+
+// CollectSelected is CollectRegistered for the collectors keep reports true
+// for, every one if keep is nil.
+func CollectSelected(pid int, st *PidStats, keep func(MetricCollector) bool) error {
+	return collectSelected("/proc", pid, st, keep)
+}
+
+// collectSelected has the built-in collectors read proc(5) at root.
+func collectSelected(root string, pid int, st *PidStats, keep func(MetricCollector) bool) error {
+	for _, c := range Collectors() {
+		if keep != nil && !keep(c) {
+			continue
+		}
+		var v int64
+		var err error
+		if rc, ok := c.(rootCollector); ok {
+			v, err = rc.collectAt(root, pid)
+		} else {
+			v, err = c.Collect(pid)
+		}
+		if errors.Is(err, ErrUnavailable) {
+			continue
+		}
+		if errors.Is(err, os.ErrPermission) {
+			if st.Errors == nil {
+				st.Errors = make(map[string]string)
+			}
+			st.Errors[c.Name()] = errorState(err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name(), err)
+		}
+		if cum, ok := c.(Cumulative); ok && cum.Cumulative() {
+			if st.Counters == nil {
+				st.Counters = make(map[string]int64)
+			}
+			st.Counters[c.Name()] = v
+		} else {
+			if st.Gauges == nil {
+				st.Gauges = make(map[string]int64)
+			}
+			st.Gauges[c.Name()] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package procmon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClkTck is the clock ticks per second used by /proc/<pid>/stat times. This
+// is 100 on every mainstream Linux architecture.
+const ClkTck = 100
+
+var pageSize = int64(os.Getpagesize())
+
+// StatFields splits /proc/<pid>/stat into fields, keeping the comm field
+// intact even if it contains spaces or parentheses. Field numbers are as in
+// proc(5) minus one.
+func StatFields(dat string) []string {
+	open := strings.Index(dat, "(")
+	end := strings.LastIndex(dat, ")")
+	if open < 0 || end < open {
+		return strings.Fields(dat)
+	}
+	fields := []string{strings.TrimSpace(dat[:open]), dat[open+1 : end]}
+	return append(fields, strings.Fields(dat[end+1:])...)
+}
+
+// BootTime returns the system boot time in unix seconds from the btime line
+// of <root>/stat.
+func BootTime(root string) (int64, error) {
+	dat, err := ioutil.ReadFile(root + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			return strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s/stat: no btime", root)
+}
+
+// StartTime converts the starttime field of /proc/<pid>/stat into a unix
+// time given the boot time.
+func StartTime(bootTime, starttime int64) time.Time {
+	return time.Unix(bootTime+starttime/ClkTck, (starttime%ClkTck)*int64(time.Second/ClkTck))
+}
+
+// FindByName returns every pid under root whose comm is name.
+func FindByName(root, name string) []int {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dat, err := ioutil.ReadFile(root + "/" + e.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		if s := StatFields(string(dat)); len(s) > 1 && s[1] == name {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// ProcSource reads the registered metrics, the built-in cpu, rss, vsize and
+// threads included, from proc(5) mounted at Root, /proc if empty. The boot
+// time is read from <Root>/stat once.
+type ProcSource struct {
+	Root string
+}
+
+// bootTimes has the boot time of every root a ProcSource read from.
+var (
+	bootTimesLock sync.Mutex
+	bootTimes     = make(map[string]int64)
+)
+
+func (p ProcSource) bootTime() (int64, error) {
+	bootTimesLock.Lock()
+	defer bootTimesLock.Unlock()
+	if boot, ok := bootTimes[p.root()]; ok {
+		return boot, nil
+	}
+	boot, err := BootTime(p.root())
+	if err != nil {
+		return 0, err
+	}
+	bootTimes[p.root()] = boot
+	return boot, nil
+}
+
+func (p ProcSource) root() string {
+	if p.Root == "" {
+		return "/proc"
+	}
+	return p.Root
+}
+
+func (p ProcSource) ReadStats(pid int) (PidStats, error) {
+	dir := p.root() + "/" + strconv.Itoa(pid)
+	dat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return PidStats{}, err
+	}
+	s := StatFields(string(dat))
+	if len(s) < 22 {
+		return PidStats{}, fmt.Errorf("%s/stat: truncated", dir)
+	}
+	boot, err := p.bootTime()
+	if err != nil {
+		return PidStats{}, err
+	}
+	st := PidStats{StartTime: StartTime(boot, atoi64(s[21]))}
+	if err := collectSelected(p.root(), pid, &st, nil); err != nil {
+		return PidStats{}, err
+	}
+	return st, nil
+}
+
+func atoi64(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
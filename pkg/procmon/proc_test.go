@@ -0,0 +1,133 @@
+package procmon
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testRoot is a synthetic proc(5) tree: two nginx processes, one with a comm
+// containing spaces and parentheses and one with a truncated stat.
+const testRoot = "testdata/proc"
+
+func TestStatFields(t *testing.T) {
+	tests := []struct {
+		stat string
+		want []string
+	}{
+		{"1 (init) S 0", []string{"1", "init", "S", "0"}},
+		{"42 (tmux: server) R 1", []string{"42", "tmux: server", "R", "1"}},
+		{"7 (a) (b)) Z 1", []string{"7", "a) (b)", "Z", "1"}},
+		{"9 (cut", []string{"9", "(cut"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := StatFields(tt.stat)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("StatFields(%q) = %q, want %q", tt.stat, got, tt.want)
+		}
+	}
+}
+
+func TestBootTime(t *testing.T) {
+	boot, err := BootTime(testRoot)
+	if err != nil || boot != 1700000000 {
+		t.Errorf("BootTime = %d, %v, want 1700000000", boot, err)
+	}
+	if _, err := BootTime("testdata/missing"); err == nil {
+		t.Error("BootTime of a missing root succeeded")
+	}
+}
+
+func TestFindByName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{"nginx", []int{100, 101}},
+		{"my (odd) proc", []int{200}},
+		{"broken", []int{400}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		if got := FindByName(testRoot, tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindByName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcSourceRoot(t *testing.T) {
+	st, err := ProcSource{Root: testRoot}.ReadStats(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1700000060, 0); !st.StartTime.Equal(want) {
+		t.Errorf("StartTime = %v, want %v", st.StartTime, want)
+	}
+	if st.Counters["cpu"] != 15 {
+		t.Errorf("cpu = %d, want 15", st.Counters["cpu"])
+	}
+	want := map[string]int64{"threads": 3, "rss": 1024 * pageSize, "vsize": 5120 * pageSize}
+	for name, v := range want {
+		if st.Gauges[name] != v {
+			t.Errorf("%s = %d, want %d", name, st.Gauges[name], v)
+		}
+	}
+	if _, err := (ProcSource{Root: testRoot}).ReadStats(400); err == nil {
+		t.Error("ReadStats of a truncated stat succeeded")
+	}
+	if _, err := (ProcSource{Root: testRoot}).ReadStats(999); err == nil {
+		t.Error("ReadStats of a missing pid succeeded")
+	}
+}
+
+func TestCollectorRoot(t *testing.T) {
+	for _, source := range []Source{ProcSource{Root: testRoot}, &ProcSource{Root: testRoot}} {
+		c := NewCollector(source, "nginx")
+		samples, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := samples["nginx"]
+		if !ok {
+			t.Errorf("%T: nginx not collected", source)
+			continue
+		}
+		if s.Pid != 100 || s.Metrics["rss"] != 256*pageSize {
+			t.Errorf("%T: pid %d rss %d, want pid 100 rss %d", source, s.Pid, s.Metrics["rss"], 256*pageSize)
+		}
+	}
+}
+
+func TestProcSourceBootTimeOnce(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"stat", "100/stat", "100/statm"} {
+		dat, err := ioutil.ReadFile(filepath.Join(testRoot, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(root, path), dat, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := ProcSource{Root: root}
+	if _, err := p.ReadStats(100); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(root, "stat"))
+	st, err := p.ReadStats(100)
+	if err != nil {
+		t.Fatalf("ReadStats re-read the boot time: %v", err)
+	}
+	if want := time.Unix(1700000050, 0); !st.StartTime.Equal(want) {
+		t.Errorf("StartTime = %v, want %v", st.StartTime, want)
+	}
+}
@@ -0,0 +1,42 @@
+// Package procmon samples per process metrics and turns cumulative counters
+// into per interval deltas. It is the collector of linux-proc-exporter,
+// usable on its own:
+//
+//	c := procmon.NewCollector(procmon.ProcSource{}, "nginx", "postgres")
+//	for range time.Tick(time.Second) {
+//		samples, err := c.Collect(ctx)
+//		...
+//	}
+package procmon
+
+import "time"
+
+// Sample is one collection of a target. Time is in unix milliseconds.
+type Sample struct {
+	Time    int64            `json:"time"`
+	Pid     int              `json:"pid"`
+	Metrics map[string]int64 `json:"metrics"`
+}
+
+// Series is the sample history of a target, oldest first.
+type Series struct {
+	Target  string   `json:"target"`
+	Samples []Sample `json:"samples"`
+}
+
+// PidStats is what a Source reports for one process. Counters are
+// cumulative and turned into per interval deltas by the Collector, gauges
+// are reported as read.
+type PidStats struct {
+	StartTime time.Time
+	Counters  map[string]int64
+	Gauges    map[string]int64
+	// Errors has the metrics left out because they couldn't be read, with
+	// why, e.g. "EACCES".
+	Errors map[string]string
+}
+
+// Source reads the stats of a process.
+type Source interface {
+	ReadStats(pid int) (PidStats, error)
+}
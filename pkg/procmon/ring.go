@@ -0,0 +1,113 @@
+package procmon
+
+import "unsafe"
+
+// Ring is a sample history of fixed size: once full, each Push overwrites
+// the oldest sample instead of growing the backing array. The zero value
+// is empty with size 0, see Resize.
+type Ring struct {
+	buf   []Sample
+	start int
+	n     int
+}
+
+// NewRing returns a ring holding samples, oldest first, sized to fit them.
+func NewRing(samples []Sample) Ring {
+	return Ring{buf: samples, n: len(samples)}
+}
+
+// Len returns the number of samples held.
+func (r *Ring) Len() int {
+	return r.n
+}
+
+// Size returns the number of samples the ring holds when full.
+func (r *Ring) Size() int {
+	return len(r.buf)
+}
+
+// At returns the i-th sample, oldest first.
+func (r *Ring) At(i int) Sample {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// Last returns the newest sample, false if the ring is empty.
+func (r *Ring) Last() (Sample, bool) {
+	if r.n == 0 {
+		return Sample{}, false
+	}
+	return r.At(r.n - 1), true
+}
+
+// Push adds s, dropping the oldest sample when the ring is full.
+func (r *Ring) Push(s Sample) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = s
+		r.n++
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// DropBefore drops the samples older than t, in unix ms.
+func (r *Ring) DropBefore(t int64) {
+	for r.n > 0 && r.At(0).Time < t {
+		r.buf[r.start] = Sample{}
+		r.start = (r.start + 1) % len(r.buf)
+		r.n--
+	}
+}
+
+// Resize changes the size of the ring to size samples, keeping the newest.
+func (r *Ring) Resize(size int) {
+	if size == len(r.buf) {
+		return
+	}
+	keep := r.n
+	if keep > size {
+		keep = size
+	}
+	buf := make([]Sample, size)
+	for i := 0; i < keep; i++ {
+		buf[i] = r.At(r.n - keep + i)
+	}
+	r.buf, r.start, r.n = buf, 0, keep
+}
+
+// Slice returns a copy of the samples, oldest first.
+func (r *Ring) Slice() []Sample {
+	samples := make([]Sample, r.n)
+	for i := range samples {
+		samples[i] = r.At(i)
+	}
+	return samples
+}
+
+// Bytes estimates the memory held by the ring: the backing array and the
+// metric maps of the samples in it. The metric names are shared with the
+// collector and not counted.
+func (r *Ring) Bytes() int64 {
+	total := int64(len(r.buf)) * int64(unsafe.Sizeof(Sample{}))
+	for i := 0; i < r.n; i++ {
+		total += mapBytes(len(r.At(i).Metrics))
+	}
+	return total
+}
+
+// mapBytes approximates a map[string]int64 of n entries: the map header
+// and a power of two buckets of 8 entries, filled to 6.5 on average.
+func mapBytes(n int) int64 {
+	const header, bucket = 48, 8 + 8*16 + 8*8 + 8
+	if n == 0 {
+		return header
+	}
+	buckets := int64(1)
+	for float64(n) > 6.5*float64(buckets) {
+		buckets *= 2
+	}
+	return header + buckets*bucket
+}
@@ -0,0 +1,33 @@
+package procmon
+
+import "testing"
+
+func times(r *Ring) []int64 {
+	var t []int64
+	for _, s := range r.Slice() {
+		t = append(t, s.Time)
+	}
+	return t
+}
+
+func TestRingDropBefore(t *testing.T) {
+	var r Ring
+	r.Resize(4)
+	for i := int64(1); i <= 6; i++ {
+		r.Push(Sample{Time: i})
+	}
+	r.DropBefore(5)
+	if got := times(&r); len(got) != 2 || got[0] != 5 || got[1] != 6 {
+		t.Fatalf("after DropBefore(5): %v, want [5 6]", got)
+	}
+	r.Push(Sample{Time: 7})
+	r.Push(Sample{Time: 8})
+	r.Push(Sample{Time: 9})
+	if got := times(&r); len(got) != 4 || got[0] != 6 || got[3] != 9 {
+		t.Fatalf("after refilling: %v, want [6 7 8 9]", got)
+	}
+	r.DropBefore(100)
+	if r.Len() != 0 {
+		t.Fatalf("after dropping everything: %d samples", r.Len())
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"unsafe"
+)
+
+var pageSize = int64(os.Getpagesize())
+
+// nativeEndian is the byte order of netlink messages and of the addresses
+// in /proc/net.
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}()
@@ -0,0 +1,53 @@
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+// linuxPlatform reports the metrics registered with procmon.Register, see
+// metrics_linux.go and fdinfo.go, plus the per-CPU ticks and per NUMA node
+// memory if enabled. Sub-second intervals only read stat and statm, see
+// fastPath.
+type linuxPlatform struct {
+	perCPU *perCPUTicks
+}
+
+func (p linuxPlatform) ReadStats(pid int) (procmon.PidStats, error) {
+	beginPidRead(pid)
+	defer endPidRead(pid)
+	s, err := pidStat(pid)
+	if err != nil {
+		return procmon.PidStats{}, err
+	}
+	st := procmon.PidStats{
+		StartTime: GetStartTime(s.Int(21)),
+		Counters:  make(map[string]int64),
+		Gauges:    make(map[string]int64),
+	}
+	if err := procmon.CollectSelected(pid, &st, collectedMetric); err != nil {
+		return procmon.PidStats{}, err
+	}
+	if fastPath {
+		return st, nil
+	}
+	if perCPUEnabled {
+		for name, v := range p.perCPU.read(pid) {
+			st.Gauges[name] = v
+		}
+	}
+	if numaEnabled {
+		// left out when numa_maps can't be read, e.g. without NUMA
+		nodes, _ := GetNumaStats(pid)
+		for name, v := range nodes {
+			st.Gauges[name] = v
+		}
+	}
+	return st, nil
+}
+
+// newPlatform returns a procmon.Source of this build target with state of
+// its own, e.g. for a burst.
+func newPlatform() procmon.Source {
+	return linuxPlatform{perCPU: newPerCPUTicks()}
+}
+
+// platform is the procmon.Source of this build target.
+var platform = newPlatform()
@@ -0,0 +1,25 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"errors"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"runtime"
+)
+
+type unsupportedPlatform struct{}
+
+func (unsupportedPlatform) ReadStats(pid int) (procmon.PidStats, error) {
+	return procmon.PidStats{}, errors.New("process stats are not supported on " + runtime.GOOS)
+}
+
+// newPlatform returns a procmon.Source of this build target with state of
+// its own, e.g. for a burst.
+func newPlatform() procmon.Source {
+	return unsupportedPlatform{}
+}
+
+// platform is the procmon.Source of this build target.
+var platform = newPlatform()
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Best effort Windows support: cpu, working set, pagefile usage, handles
+// and threads. Metrics only available from /proc are not reported.
+
+var (
+	psapi                    = syscall.NewLazyDLL("psapi.dll")
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procGetProcessMemoryInfo = psapi.NewProc("GetProcessMemoryInfo")
+	procGetProcessHandleCnt  = kernel32.NewProc("GetProcessHandleCount")
+)
+
+const processQueryLimitedInformation = 0x1000
+
+type processMemoryCounters struct {
+	CB                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+func filetimeTicks(ft syscall.Filetime) int64 {
+	// 100ns units, converted to the clkTck units used on Linux.
+	return (int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)) / (1e7 / clkTck)
+}
+
+func threadCount(pid int) (int64, error) {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(snap)
+	var pe syscall.ProcessEntry32
+	pe.Size = uint32(unsafe.Sizeof(pe))
+	for err = syscall.Process32First(snap, &pe); err == nil; err = syscall.Process32Next(snap, &pe) {
+		if int(pe.ProcessID) == pid {
+			return int64(pe.Threads), nil
+		}
+	}
+	return 0, errors.New("process not found")
+}
+
+type windowsPlatform struct{}
+
+func (windowsPlatform) ReadStats(pid int) (procmon.PidStats, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return procmon.PidStats{}, err
+	}
+	defer syscall.CloseHandle(h)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return procmon.PidStats{}, err
+	}
+	var mem processMemoryCounters
+	mem.CB = uint32(unsafe.Sizeof(mem))
+	if r, _, err := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.CB)); r == 0 {
+		return procmon.PidStats{}, err
+	}
+	var handles uint32
+	if r, _, err := procGetProcessHandleCnt.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r == 0 {
+		return procmon.PidStats{}, err
+	}
+	threads, err := threadCount(pid)
+	if err != nil {
+		return procmon.PidStats{}, err
+	}
+	return procmon.PidStats{
+		StartTime: time.Unix(0, creation.Nanoseconds()),
+		Counters: map[string]int64{
+			"cpu": filetimeTicks(kernel) + filetimeTicks(user),
+		},
+		Gauges: map[string]int64{
+			"rss":     int64(mem.WorkingSetSize),
+			"vsize":   int64(mem.PagefileUsage),
+			"threads": threads,
+			"handles": int64(handles),
+		},
+	}, nil
+}
+
+// newPlatform returns a procmon.Source of this build target with state of
+// its own, e.g. for a burst.
+func newPlatform() procmon.Source {
+	return windowsPlatform{}
+}
+
+// platform is the procmon.Source of this build target.
+var platform = newPlatform()
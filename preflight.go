@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const capSysPtrace = 19
+
+// hasCapability reports whether the exporter has capability cap in its
+// effective set, false if that can't be told. This is about the exporter
+// itself, so it is read from the real /proc whatever -proc-root is.
+func hasCapability(cap uint) bool {
+	dat, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if strings.HasPrefix(line, "CapEff:") {
+			caps, err := strconv.ParseUint(strings.TrimSpace(line[len("CapEff:"):]), 16, 64)
+			return err == nil && caps&(1<<cap) != 0
+		}
+	}
+	return false
+}
+
+// preflight runs after the first collection and prints a warning for every
+// target with metrics that can't be read with the exporter's privileges,
+// so that empty charts are explained in the log and not only on the
+// dashboard.
+func preflight() {
+	who := fmt.Sprintf("uid %d", os.Getuid())
+	if !hasCapability(capSysPtrace) {
+		who += " without CAP_SYS_PTRACE"
+	}
+	for _, t := range GetTargetStatus() {
+		byError := make(map[string][]string)
+		for m, e := range t.MetricErrors {
+			byError[e] = append(byError[e], m)
+		}
+		var errs []string
+		for e := range byError {
+			errs = append(errs, e)
+		}
+		sort.Strings(errs)
+		for _, e := range errs {
+			metrics := byError[e]
+			sort.Strings(metrics)
+			fmt.Printf("warning: %s of %s can't be read (%s) as %s; run as the same user as the process or root, grant CAP_SYS_PTRACE or use -reader-socket\n",
+				strings.Join(metrics, ", "), t.Name, e, who)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package main
+
+/*
+#include <stdlib.h>
+#include <sys/prctl.h>
+
+#ifndef PR_CAP_AMBIENT
+#define PR_CAP_AMBIENT 47
+#define PR_CAP_AMBIENT_CLEAR_ALL 4
+#endif
+
+// Runs before the Go runtime starts its threads, which inherit the cleared
+// ambient set, see clearAmbientCaps.
+__attribute__((constructor)) static void procmon_clear_ambient(void) {
+	if (getenv("PROC_EXPORTER_LISTEN_FDS") != NULL) {
+		prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0);
+	}
+}
+*/
+import "C"
+
+// clearAmbientCaps is done by the constructor above: with cgo the ambient
+// set can't be changed on every thread from Go.
+func clearAmbientCaps() error {
+	return nil
+}
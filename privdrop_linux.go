@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Capabilities -keep-caps may retain, see capabilities(7).
+var keepableCaps = map[string]uint{
+	"dac_read_search": 2,
+	"sys_ptrace":      19,
+}
+
+const (
+	prCapAmbient      = 47
+	prCapAmbientRaise = 2
+	// prCapAmbientClearAll is used by clearAmbientCaps.
+	prCapAmbientClearAll = 4
+	capVersion3          = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// runAsIds resolves "user[:group]", names or numeric ids. Without a group
+// the primary group of the user is used, and the supplementary groups are
+// those of the user when it has an entry in the user database.
+func runAsIds(spec string) (uid, gid int, groups []int, err error) {
+	name, group := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+	if uid, err = lookupUid(name); err != nil {
+		return 0, 0, nil, err
+	}
+	gid = -1
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		gid, _ = strconv.Atoi(u.Gid)
+		ids, _ := u.GroupIds()
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	if gid < 0 {
+		return 0, 0, nil, fmt.Errorf("-run-as %s: no group for uid %d", spec, uid)
+	}
+	return uid, gid, groups, nil
+}
+
+// parseKeepCaps parses the comma separated -keep-caps list into a mask.
+func parseKeepCaps(s string) (uint32, error) {
+	var mask uint32
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "cap_")
+		if name == "" {
+			continue
+		}
+		c, ok := keepableCaps[name]
+		if !ok {
+			return 0, fmt.Errorf("-keep-caps: %q is not sys_ptrace or dac_read_search", name)
+		}
+		mask |= 1 << c
+	}
+	return mask, nil
+}
+
+// dropPrivileges re-executes the exporter as spec, "user[:group]", passing
+// listeners on and keeping only the capabilities in keepCaps. Capabilities
+// belong to threads, so they are set on a locked thread right before the
+// exec, which keeps the pid for service managers. They are passed through
+// the exec in the ambient set, which is cleared again afterwards so that
+// hooks don't inherit them. It returns without doing anything else when the
+// exporter already runs as the user, e.g. after the exec.
+func dropPrivileges(spec, keepCaps string, listeners []net.Listener) error {
+	uid, gid, groups, err := runAsIds(spec)
+	if err != nil {
+		return err
+	}
+	mask, err := parseKeepCaps(keepCaps)
+	if err != nil {
+		return err
+	}
+	if os.Geteuid() == uid && os.Getegid() == gid {
+		return clearAmbientCaps()
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("-run-as %s: running as uid %d, not root", spec, os.Geteuid())
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var fds []string
+	for _, l := range listeners {
+		f, err := l.(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			return err
+		}
+		// File returns a duplicate with close-on-exec set
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETFD, 0); errno != 0 {
+			return errno
+		}
+		fds = append(fds, strconv.Itoa(int(f.Fd())))
+	}
+	env := append(os.Environ(), listenFdsEnv+"="+strings.Join(fds, ","))
+
+	runtime.LockOSThread()
+	// Only this thread keeps its capabilities through setuid, the exec
+	// replaces the others.
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_KEEPCAPS, 1, 0); errno != 0 {
+		return fmt.Errorf("PR_SET_KEEPCAPS: %v", errno)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %v", uid, err)
+	}
+	hdr := capHeader{version: capVersion3}
+	data := [2]capData{{effective: mask, permitted: mask, inheritable: mask}}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %v", errno)
+	}
+	for _, c := range keepableCaps {
+		if mask&(1<<c) == 0 {
+			continue
+		}
+		// ambient capabilities survive the exec of an unprivileged binary
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(c), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("PR_CAP_AMBIENT_RAISE %d: %v", c, errno)
+		}
+	}
+	return syscall.Exec(exe, os.Args, env)
+}
@@ -0,0 +1,15 @@
+//go:build linux && !cgo
+// +build linux,!cgo
+
+package main
+
+import "syscall"
+
+// clearAmbientCaps clears the ambient capabilities on every thread so that
+// hooks don't inherit the capabilities kept by -keep-caps.
+func clearAmbientCaps() error {
+	if _, _, errno := syscall.AllThreadsSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
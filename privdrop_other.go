@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func dropPrivileges(spec, keepCaps string, listeners []net.Listener) error {
+	return errors.New("-run-as is only available on Linux")
+}
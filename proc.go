@@ -1,15 +1,21 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"github.com/mitchellh/go-ps"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
 	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// procRoot is where proc(5) is read from by the collector.
+var procRoot = "/proc"
+
+const procRootUsage = "Where proc(5) is mounted, e.g. /host/proc for the host's /proc bind-mounted into a container."
+
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -17,41 +23,148 @@ func check(e error) {
 }
 
 func GetProcesses(processName string) int {
-	p, _ := ps.Processes()
+	pids := FindPids(processName)
+	if len(pids) == 0 {
+		return 0
+	}
+	return pids[0]
+
+}
+
+// FindPids returns every pid whose executable name is processName.
+func FindPids(processName string) []int {
+	s := currentScan()
+	return s.find(func(e procEntry) bool { return s.matchesName(e, processName) })
+}
+
+const clkTck = procmon.ClkTck
+
+var bootTime int64
+
+// GetBootTime returns the system boot time in unix seconds from the btime
+// line of /proc/stat.
+func GetBootTime() int64 {
+	if bootTime == 0 {
+		var err error
+		bootTime, err = procmon.BootTime(procRoot)
+		check(err)
+	}
+	return bootTime
+}
 
-	for _, p1 := range p {
-		if p1.Executable() == processName {
-			return p1.Pid()
+// GetSystemUptime returns the seconds since boot from /proc/uptime.
+func GetSystemUptime() (float64, error) {
+	dat, err := ioutil.ReadFile(procRoot + "/uptime")
+	if err != nil {
+		return 0, err
+	}
+	s := strings.Fields(string(dat))
+	if len(s) == 0 {
+		return 0, fmt.Errorf("%s/uptime: empty", procRoot)
+	}
+	return strconv.ParseFloat(s[0], 64)
+}
+
+// GetStartTime converts the starttime field of /proc/<pid>/stat into a
+// unix time.
+func GetStartTime(starttime int64) time.Time {
+	return procmon.StartTime(GetBootTime(), starttime)
+}
+
+// snapshotReads makes readPidFiles open every file before reading any, so
+// the values of one sample are read as close together as possible.
+var snapshotReads bool
+
+// readPidFiles reads the named files of /proc/<pid>.
+func readPidFiles(pid int, names ...string) ([][]byte, error) {
+	result, errs := readPidFilesEach(pid, names...)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
-	return 0
+	return result, nil
+}
 
+// readPidFilesEach reads the named files of /proc/<pid>, with the error
+// of each file that couldn't be read in errs.
+func readPidFilesEach(pid int, names ...string) (result [][]byte, errs []error) {
+	dir := procRoot + "/" + strconv.Itoa(pid) + "/"
+	result = make([][]byte, len(names))
+	errs = make([]error, len(names))
+	if !snapshotReads || readerSocket != "" {
+		for i, name := range names {
+			result[i], errs[i] = readProcFile(dir + name)
+		}
+		return result, errs
+	}
+	files := make([]*os.File, len(names))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, name := range names {
+		files[i], errs[i] = os.Open(dir + name)
+	}
+	// proc files are generated on read, so this is when the values are taken
+	buf := make([]byte, 4096)
+	for i, f := range files {
+		if f == nil {
+			continue
+		}
+		n, err := f.Read(buf)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		dat := append([]byte(nil), buf[:n]...)
+		if n == len(buf) {
+			rest, err := ioutil.ReadAll(f)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			dat = append(dat, rest...)
+		}
+		result[i] = dat
+	}
+	return result, errs
 }
-func GetProcessStats(processName string) map[string]string {
+
+func GetPidStats(pid int) (map[string]string, error) {
 	m := make(map[string]string)
-	pid := GetProcesses(processName)
-	if pid == 0 {
-		return m
+	statFilename := procRoot + "/" + strconv.Itoa(pid) + "/stat"
+	statmFilename := procRoot + "/" + strconv.Itoa(pid) + "/statm"
+	files, err := readPidFiles(pid, "stat", "statm")
+	if err != nil {
+		return m, err
 	}
-	statFilename := "/proc/" + strconv.Itoa(pid) + "/stat"
-	dat, err := ioutil.ReadFile(statFilename)
-	check(err)
+	dat := files[0]
 	//fmt.Print(string(dat))
-	s := strings.Split(string(dat), " ")
+	s := procmon.StatFields(string(dat))
+	if len(s) < 22 {
+		return m, fmt.Errorf("%s: truncated", statFilename)
+	}
 	//fmt.Println(s[10])
 	//pidd := s[0]
 	utime := s[13]
 	ktime := s[14]
+	threads := s[19]
+	starttime := s[21]
 	//	vsize := s[22]
 	//	rsize := s[23]
 
 	//fmt.Println("pid", pidd, "utime: ", utime, "ktime:", ktime, "vsize", vsize, "rsize", rsize)
 
-	statmFilename := "/proc/" + strconv.Itoa(pid) + "/statm"
-	dat, err = ioutil.ReadFile(statmFilename)
-	check(err)
+	dat = files[1]
 	//fmt.Print(string(dat))
-	sm := strings.Split(string(dat), " ")
+	sm := strings.Fields(string(dat))
+	if len(sm) < 2 {
+		return m, fmt.Errorf("%s: truncated", statmFilename)
+	}
 	vsizem := sm[0]
 	rsizem := sm[1]
 	//	datam := sm[5]
@@ -61,31 +174,65 @@ func GetProcessStats(processName string) map[string]string {
 	m["rsizem"] = rsizem
 	m["utime"] = utime
 	m["ktime"] = ktime
+	m["threads"] = threads
+	m["starttime"] = starttime
+	return m, nil
+}
+
+func GetProcessStats(processName string) map[string]string {
+	pid := GetProcesses(processName)
+	if pid == 0 {
+		return make(map[string]string)
+	}
+	m, err := GetPidStats(pid)
+	check(err)
 	return m
 }
 
-func MonitorProcessStats(processName string) {
-	utimeCurrent := 0
-	ktimeCurrent := 0
-	utimePrevious := 0
-	ktimePrevious := 0
-	cpuLastSecond := 0
-	fmt.Println("Monitoring stats for", processName)
-	for {
-		utimePrevious = utimeCurrent
-		ktimePrevious = ktimeCurrent
-		m := GetProcessStats(processName)
-		utimeCurrent, _ = strconv.Atoi(m["utime"])
-		ktimeCurrent, _ = strconv.Atoi(m["ktime"])
-		cpuLastSecond = (utimeCurrent + ktimeCurrent) - (utimePrevious + ktimePrevious)
-		fmt.Println("utime:", m["utime"], "ktime:", m["ktime"], "vsize:", m["vsizem"], "rsizem", m["rsizem"], "cpu last sec", cpuLastSecond)
-		time.Sleep(1 * time.Second)
+// missedScrapes counts the cycles skipped because the one before overran
+// the interval.
+var missedScrapes int64
 
+// collectOnSchedule collects the due targets and, if it took longer than
+// the tick interval, drops the tick that came in meanwhile so that the next
+// cycle starts on the ticker's schedule rather than right away.
+func collectOnSchedule(ticker *time.Ticker) {
+	start := time.Now()
+	collectDue(func(ps *ProcessStats) bool { return isDue(ps, start) })
+	elapsed := time.Since(start)
+	statsLock.RLock()
+	interval := tickInterval()
+	statsLock.RUnlock()
+	if elapsed <= interval {
+		return
+	}
+	select {
+	case <-ticker.C:
+	default:
 	}
+	missed := int64(elapsed / interval)
+	atomic.AddInt64(&missedScrapes, missed)
+	fmt.Printf("collection took %v, more than the %v interval; skipped %d cycles\n", elapsed.Round(time.Millisecond), interval, missed)
 }
 
-func main() {
-	var name = flag.String("name", "python2", "Process name to monitor.")
-	flag.Parse()
-	MonitorProcessStats(*name)
+// MonitorProcessStats collects every collectInterval until stop is closed.
+func MonitorProcessStats(stop <-chan struct{}) {
+	fmt.Println("Monitoring stats for", strings.Join(TargetNames(), ", "))
+	statsLock.RLock()
+	ticker := time.NewTicker(tickInterval())
+	statsLock.RUnlock()
+	defer func() { ticker.Stop() }()
+	collectOnSchedule(ticker)
+	preflight()
+	for {
+		select {
+		case <-ticker.C:
+		case d := <-intervalChanged:
+			ticker.Stop()
+			ticker = time.NewTicker(d)
+		case <-stop:
+			return
+		}
+		collectOnSchedule(ticker)
+	}
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// proc connector constants from linux/connector.h and linux/cn_proc.h
+const (
+	netlinkConnector  = 11
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	procEventFork     = 0x1
+	procEventExec     = 0x2
+	procEventSid      = 0x80
+	procEventComm     = 0x200
+	procEventExit     = 0x80000000
+)
+
+// procConnResync is how often the table is rebuilt from /proc anyway, for
+// what the kernel sends no event for: process group changes without an
+// exec and children reparented when their parent exits.
+const procConnResync = time.Minute
+
+// liveTable is the process table kept up to date from the fork, exec,
+// setsid, comm and exit events of the kernel proc connector.
+type liveTable struct {
+	lock    sync.Mutex
+	entries map[int]procEntry
+	// exited are the pids that exited and may not be reaped yet
+	exited map[int]bool
+	synced time.Time
+	failed bool
+	// touched are the pids with events while walking /proc, nil otherwise
+	touched map[int]bool
+
+	// scratch space of the event loop
+	buf *[]byte
+	f   procFields
+}
+
+// procConn is nil unless StartProcConnector succeeded.
+var procConn *liveTable
+
+// StartProcConnector subscribes to the process events of the kernel, so
+// that the process table is maintained from them instead of walking /proc
+// every cycle. Older kernels only allow it with CAP_NET_ADMIN.
+func StartProcConnector() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkConnector)
+	if err != nil {
+		return err
+	}
+	// a bigger buffer for fork storms, events are lost when it's full
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1<<20)
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc})
+	if err == nil {
+		err = sendProcListen(fd)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		if errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("%w, CAP_NET_ADMIN is needed", err)
+		}
+		return err
+	}
+	t := &liveTable{exited: make(map[int]bool), buf: new([]byte)}
+	t.entries = entriesByPid(walkProcesses().entries)
+	t.synced = time.Now()
+	procConn = t
+	go t.run(fd)
+	return nil
+}
+
+// sendProcListen sends PROC_CN_MCAST_LISTEN in a cn_msg.
+func sendProcListen(fd int) error {
+	msg := make([]byte, syscall.NLMSG_HDRLEN+20+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[16:], cnIdxProc)
+	nativeEndian.PutUint32(msg[20:], cnValProc)
+	nativeEndian.PutUint16(msg[32:], 4)
+	nativeEndian.PutUint32(msg[36:], procCnMcastListen)
+	return syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+func entriesByPid(entries []procEntry) map[int]procEntry {
+	m := make(map[int]procEntry, len(entries))
+	for _, e := range entries {
+		m[e.pid] = e
+	}
+	return m
+}
+
+func (t *liveTable) run(fd int) {
+	defer syscall.Close(fd)
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.ENOBUFS {
+			// events were dropped, walk /proc at the next scan
+			t.lock.Lock()
+			t.synced = time.Time{}
+			t.lock.Unlock()
+			continue
+		}
+		if err != nil {
+			fmt.Println("proc connector:", err, "- scanning /proc every cycle instead")
+			t.lock.Lock()
+			t.failed = true
+			t.lock.Unlock()
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			t.handle(m.Data)
+		}
+	}
+}
+
+// handle applies a proc_event following its cn_msg header. Only thread
+// group leaders are in the table.
+func (t *liveTable) handle(b []byte) {
+	if len(b) < 20+16+8 || nativeEndian.Uint32(b[0:]) != cnIdxProc || nativeEndian.Uint32(b[4:]) != cnValProc {
+		return
+	}
+	what := nativeEndian.Uint32(b[20:])
+	data := b[36:]
+	pid, tgid := nativeEndian.Uint32(data[0:]), nativeEndian.Uint32(data[4:])
+	switch what {
+	case procEventFork:
+		if len(data) < 16 {
+			return
+		}
+		pid, tgid = nativeEndian.Uint32(data[8:]), nativeEndian.Uint32(data[12:])
+	case procEventExec, procEventSid, procEventComm, procEventExit:
+	default:
+		return
+	}
+	if pid != tgid {
+		return
+	}
+	if what == procEventExit {
+		t.lock.Lock()
+		if e, ok := t.entries[int(pid)]; ok {
+			e.state = "Z"
+			t.entries[int(pid)] = e
+			t.exited[int(pid)] = true
+		}
+		t.markTouched(int(pid))
+		t.lock.Unlock()
+		return
+	}
+	e, ok := readProcEntry(int(pid), t.buf, &t.f)
+	t.lock.Lock()
+	if ok {
+		t.entries[e.pid] = e
+	} else {
+		delete(t.entries, int(pid))
+	}
+	delete(t.exited, int(pid))
+	t.markTouched(int(pid))
+	t.lock.Unlock()
+}
+
+func (t *liveTable) markTouched(pid int) {
+	if t.touched != nil {
+		t.touched[pid] = true
+	}
+}
+
+// liveScan returns the process table from the proc connector, nil if it
+// isn't running.
+func liveScan() *procScan {
+	t := procConn
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	if t.failed {
+		t.lock.Unlock()
+		return nil
+	}
+	if time.Since(t.synced) > procConnResync && t.touched == nil {
+		t.touched = make(map[int]bool)
+		t.lock.Unlock()
+		s := walkProcesses()
+		t.lock.Lock()
+		// the events during the walk are newer than what it read
+		entries := entriesByPid(s.entries)
+		for pid := range t.touched {
+			if e, ok := t.entries[pid]; ok {
+				entries[pid] = e
+			} else {
+				delete(entries, pid)
+			}
+		}
+		for pid, e := range entries {
+			if e.state == "Z" {
+				t.exited[pid] = true
+			}
+		}
+		t.entries = entries
+		t.touched = nil
+		t.synced = time.Now()
+	}
+	// zombies are reaped without an event
+	if len(t.exited) > 0 {
+		buf := procBuffers.Get().(*[]byte)
+		var f procFields
+		for pid := range t.exited {
+			if e, ok := readProcEntry(pid, buf, &f); ok && e.state == "Z" {
+				t.entries[pid] = e
+				continue
+			}
+			delete(t.entries, pid)
+			delete(t.exited, pid)
+		}
+		procBuffers.Put(buf)
+	}
+	s := &procScan{argv0: make(map[int]string), uids: make(map[int]int), live: true}
+	s.entries = make([]procEntry, 0, len(t.entries))
+	for _, e := range t.entries {
+		s.entries = append(s.entries, e)
+	}
+	t.lock.Unlock()
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].pid < s.entries[j].pid })
+	return s
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func StartProcConnector() error {
+	return errors.New("the proc connector is only available on Linux")
+}
+
+func liveScan() *procScan {
+	return nil
+}
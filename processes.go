@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// processesHandler serves /api/v1/processes: GET lists the monitored
+// targets (?status=1 with the status of their last collection), POST
+// {"name": "nginx"} adds one.
+func processesHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+		if req.URL.Query().Get("federated") != "" {
+			json.NewEncoder(w).Encode(append(TargetNames(), FederatedTargets()...))
+			return
+		}
+		if req.URL.Query().Get("status") != "" {
+			json.NewEncoder(w).Encode(GetTargetStatus())
+			return
+		}
+		if req.URL.Query().Get("details") == "" {
+			json.NewEncoder(w).Encode(TargetNames())
+			return
+		}
+		json.NewEncoder(w).Encode(GetProcessDetails())
+	case http.MethodPost:
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Name == "" {
+			http.Error(w, `expected {"name": "<target>"}`, http.StatusBadRequest)
+			return
+		}
+		if !AddTarget(body.Name) {
+			http.Error(w, body.Name+" is already monitored", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(body)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GetProcessDetails describes every target with the status of its last
+// collection. The targets are resolved from the same scan of the process
+// table, the last collection's if it is recent, see currentScan.
+func GetProcessDetails() []ProcessDetail {
+	statuses := targetStatuses()
+	result := []ProcessDetail{}
+	for _, name := range TargetNames() {
+		result = append(result, processDetail(name, statuses))
+	}
+	return result
+}
+
+func getProcessDetail(name string) ProcessDetail {
+	return processDetail(name, targetStatuses())
+}
+
+// targetStatuses returns GetTargetStatus by target name.
+func targetStatuses() map[string]TargetStatus {
+	statuses := make(map[string]TargetStatus)
+	for _, status := range GetTargetStatus() {
+		statuses[status.Name] = status
+	}
+	return statuses
+}
+
+func processDetail(name string, statuses map[string]TargetStatus) ProcessDetail {
+	d := GetProcessDetail(name)
+	if status, ok := statuses[name]; ok {
+		d.Status = &status
+	}
+	return d
+}
+
+// processHandler serves GET and DELETE /api/v1/processes/{name},
+// /api/v1/processes/{name}/signal, /api/v1/processes/{name}/burst and
+// /api/v1/processes/{name}/fds.
+func processHandler(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/api/v1/processes/")
+	if strings.HasSuffix(name, "/signal") {
+		signalHandler(w, req, strings.TrimSuffix(name, "/signal"))
+		return
+	}
+	if strings.HasSuffix(name, "/burst") {
+		burstHandler(w, req, strings.TrimSuffix(name, "/burst"))
+		return
+	}
+	if strings.HasSuffix(name, "/fds") {
+		limitExpensive(func(w http.ResponseWriter, req *http.Request) {
+			fdsHandler(w, req, strings.TrimSuffix(name, "/fds"))
+		})(w, req)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		if !HasTarget(name) {
+			http.Error(w, name+" is not monitored", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(getProcessDetail(name))
+		return
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !RemoveTarget(name) {
+		http.Error(w, name+" is not monitored", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
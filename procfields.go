@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxProcFields is more than stat has, 52 fields on current kernels.
+const maxProcFields = 64
+
+// procFields are the space separated fields of a /proc file as offsets
+// into its read buffer, so that splitting and reading them allocates
+// nothing. Fields past maxProcFields are dropped.
+type procFields struct {
+	dat []byte
+	n   int
+	pos [maxProcFields][2]int
+}
+
+var (
+	procBuffers = sync.Pool{New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	}}
+	procFieldsPool = sync.Pool{New: func() interface{} { return new(procFields) }}
+)
+
+// readFileInto reads path into *buf, growing it if needed.
+func readFileInto(path string, buf *[]byte) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := (*buf)[:0]
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := f.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	*buf = b
+	return b, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t'
+}
+
+// appendFields adds the runs of non-space bytes in dat[from:to] to the
+// fields.
+func (f *procFields) appendFields(from, to int) {
+	for i := from; i < to && f.n < maxProcFields; {
+		for i < to && isSpace(f.dat[i]) {
+			i++
+		}
+		start := i
+		for i < to && !isSpace(f.dat[i]) {
+			i++
+		}
+		if i > start {
+			f.pos[f.n] = [2]int{start, i}
+			f.n++
+		}
+	}
+}
+
+// split sets the fields to the space separated words of dat.
+func (f *procFields) split(dat []byte) {
+	f.dat, f.n = dat, 0
+	f.appendFields(0, len(dat))
+}
+
+// splitStat splits the contents of /proc/<pid>/stat like
+// procmon.StatFields, taking the comm in parentheses as one field.
+func (f *procFields) splitStat(dat []byte) {
+	open := bytes.IndexByte(dat, '(')
+	end := bytes.LastIndexByte(dat, ')')
+	if open < 0 || end < open {
+		f.split(dat)
+		return
+	}
+	start, stop := 0, open
+	for start < stop && isSpace(dat[start]) {
+		start++
+	}
+	for stop > start && isSpace(dat[stop-1]) {
+		stop--
+	}
+	f.dat, f.n = dat, 2
+	f.pos[0] = [2]int{start, stop}
+	f.pos[1] = [2]int{open + 1, end}
+	f.appendFields(end+1, len(dat))
+}
+
+func (f *procFields) Len() int {
+	return f.n
+}
+
+func (f *procFields) Bytes(i int) []byte {
+	return f.dat[f.pos[i][0]:f.pos[i][1]]
+}
+
+// Int parses field i like atoi64, 0 if it isn't a number.
+func (f *procFields) Int(i int) int64 {
+	return parseInt(f.Bytes(i))
+}
+
+// parseInt parses a decimal integer without converting b to a string, 0
+// if it isn't one.
+func parseInt(b []byte) int64 {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return 0
+	}
+	var v int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		v = v*10 + int64(c-'0')
+	}
+	if neg {
+		return -v
+	}
+	return v
+}
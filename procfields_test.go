@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+const testStat = "1234 (my (odd) proc) S 1 1234 1234 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 3 0 5000 10485760 256 18446744073709551615\n"
+
+func TestSplitStat(t *testing.T) {
+	tests := []struct {
+		name  string
+		stat  string
+		n     int
+		comm  string
+		state string
+	}{
+		{"plain", "1 (init) S 0 1 1 0 -1 4194560", 9, "init", "S"},
+		{"spaces in comm", "42 (tmux: server) R 1 42 42", 6, "tmux: server", "R"},
+		{"parenthesis in comm", testStat, 25, "my (odd) proc", "S"},
+		{"comm ending in )", "7 (a)) Z 1", 4, "a)", "Z"},
+		{"truncated after comm", "9 (cut", 2, "", ""},
+		{"truncated before state", "9 (cut)", 2, "cut", ""},
+		{"empty", "", 0, "", ""},
+	}
+	for _, tt := range tests {
+		var f procFields
+		f.splitStat([]byte(tt.stat))
+		if f.Len() != tt.n {
+			t.Errorf("%s: %d fields, want %d", tt.name, f.Len(), tt.n)
+			continue
+		}
+		if tt.comm != "" && string(f.Bytes(1)) != tt.comm {
+			t.Errorf("%s: comm %q, want %q", tt.name, f.Bytes(1), tt.comm)
+		}
+		if tt.state != "" && string(f.Bytes(2)) != tt.state {
+			t.Errorf("%s: state %q, want %q", tt.name, f.Bytes(2), tt.state)
+		}
+	}
+}
+
+func TestSplitStatFields(t *testing.T) {
+	var f procFields
+	f.splitStat([]byte(testStat))
+	for i, want := range map[int]int64{0: 1234, 3: 1, 13: 250, 14: 50, 19: 3, 21: 5000, 22: 10485760, 23: 256} {
+		if got := f.Int(i); got != want {
+			t.Errorf("field %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"42", 42},
+		{"-1", -1},
+		{"9223372036854775807", 9223372036854775807},
+		{"", 0},
+		{"-", 0},
+		{"12a", 0},
+		{" 1", 0},
+		{"1.5", 0},
+	}
+	for _, tt := range tests {
+		if got := parseInt([]byte(tt.in)); got != tt.want {
+			t.Errorf("parseInt(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitStatAllocs(t *testing.T) {
+	dat := []byte(testStat)
+	f := new(procFields)
+	allocs := testing.AllocsPerRun(100, func() {
+		f.splitStat(dat)
+		f.Int(13)
+	})
+	if allocs != 0 {
+		t.Errorf("splitStat allocates %v times per run", allocs)
+	}
+}
+
+func BenchmarkSplitStat(b *testing.B) {
+	dat := []byte(testStat)
+	f := new(procFields)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.splitStat(dat)
+		f.Int(13)
+		f.Int(22)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// defaultPromWindow is what _avg and _peak cover for a scrape that sets
+// neither ?window= nor a scrape timeout, Prometheus's default interval.
+const defaultPromWindow = time.Minute
+
+// WritePrometheus writes the latest sample of every target in the
+// Prometheus text exposition format. Delta metrics also get _avg and _peak
+// series over the samples taken after since (unix ms), so bursts between
+// two scrapes still show up.
+func WritePrometheus(w io.Writer, since int64) {
+	b := GetBuildInfo()
+	fmt.Fprintln(w, "# HELP procmon_build_info Build information of the exporter.")
+	fmt.Fprintln(w, "# TYPE procmon_build_info gauge")
+	fmt.Fprintf(w, "procmon_build_info{version=\"%s\",commit=\"%s\",build_date=\"%s\",goversion=\"%s\"} 1\n",
+		promLabelEscaper.Replace(b.Version), promLabelEscaper.Replace(b.Commit),
+		promLabelEscaper.Replace(b.BuildDate), promLabelEscaper.Replace(b.GoVersion))
+	fmt.Fprintln(w, "# HELP procmon_missed_scrapes_total Collection cycles skipped because the previous one overran the interval.")
+	fmt.Fprintln(w, "# TYPE procmon_missed_scrapes_total counter")
+	fmt.Fprintf(w, "procmon_missed_scrapes_total %d\n", atomic.LoadInt64(&missedScrapes))
+	fmt.Fprintln(w, "# HELP procmon_sample_bytes Estimated memory held by the sample history of the targets.")
+	fmt.Fprintln(w, "# TYPE procmon_sample_bytes gauge")
+	fmt.Fprintf(w, "procmon_sample_bytes %d\n", GetSampleBytes())
+
+	latest := make(map[string]Sample)
+	metricNames := make(map[string]bool)
+	history := GetMetrics()
+	for name, samples := range GetFederatedMetrics() {
+		history[name] = samples
+	}
+	for name, samples := range history {
+		if len(samples) == 0 {
+			continue
+		}
+		s := samples[len(samples)-1]
+		latest[name] = s
+		for m := range s.Metrics {
+			metricNames[m] = true
+		}
+	}
+	statsLock.RLock()
+	delta := make(map[string]bool)
+	for m := range metricNames {
+		delta[m] = isDeltaMetric(m)
+	}
+	raw := rawCounters()
+	statsLock.RUnlock()
+	var names, metrics []string
+	for name := range latest {
+		names = append(names, name)
+	}
+	for m := range metricNames {
+		metrics = append(metrics, m)
+	}
+	sort.Strings(names)
+	sort.Strings(metrics)
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# TYPE procmon_%s gauge\n", m)
+		for _, name := range names {
+			v, ok := latest[name].Metrics[m]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "procmon_%s{%s} %d\n", m, promTargetLabels(name), v)
+		}
+		if !delta[m] {
+			continue
+		}
+		writeRawCounter(w, m, names, latest, raw)
+		var avg, peak []string
+		for _, name := range names {
+			var sum, max int64
+			n := 0
+			for _, s := range history[name] {
+				v, ok := s.Metrics[m]
+				// at least the latest sample on the first scrape
+				if !ok || (s.Time <= since && s.Time != latest[name].Time) {
+					continue
+				}
+				if n == 0 || v > max {
+					max = v
+				}
+				sum += v
+				n++
+			}
+			if n == 0 {
+				continue
+			}
+			labels := promTargetLabels(name)
+			avg = append(avg, fmt.Sprintf("procmon_%s_avg{%s} %g\n", m, labels, float64(sum)/float64(n)))
+			peak = append(peak, fmt.Sprintf("procmon_%s_peak{%s} %d\n", m, labels, max))
+		}
+		fmt.Fprintf(w, "# HELP procmon_%s_avg Average per interval value over the scrape window.\n", m)
+		fmt.Fprintf(w, "# TYPE procmon_%s_avg gauge\n", m)
+		fmt.Fprint(w, strings.Join(avg, ""))
+		fmt.Fprintf(w, "# HELP procmon_%s_peak Highest per interval value over the scrape window.\n", m)
+		fmt.Fprintf(w, "# TYPE procmon_%s_peak gauge\n", m)
+		fmt.Fprint(w, strings.Join(peak, ""))
+	}
+	writePerPid(w, names, metrics, latest)
+}
+
+// rawCountersEnabled adds procmon_<metric>_total with the cumulative value
+// of every counter, as read, for consumers that rather rate() themselves.
+var rawCountersEnabled bool
+
+// rawCounters returns the counters as last read per plain target, nil if
+// raw_counters is off. The sum over a group isn't monotonic, members come
+// and go, so groups have none. Callers hold statsLock.
+func rawCounters() map[string]map[string]int64 {
+	if !rawCountersEnabled {
+		return nil
+	}
+	raw := make(map[string]map[string]int64)
+	for name, ps := range statsMap {
+		if len(ps.Pids) > 0 && !isGroupTarget(name) {
+			raw[name] = ps.Baselines()[ps.Pids[0]]
+		}
+	}
+	return raw
+}
+
+func writeRawCounter(w io.Writer, m string, names []string, latest map[string]Sample, raw map[string]map[string]int64) {
+	var lines []string
+	for _, name := range names {
+		// not when the metric is deselected
+		if _, ok := latest[name].Metrics[m]; !ok {
+			continue
+		}
+		if v, ok := raw[name][m]; ok {
+			lines = append(lines, fmt.Sprintf("procmon_%s_total{%s} %d\n", m, promTargetLabels(name), v))
+		}
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(w, "# HELP procmon_%s_total Cumulative value of the counter as read, e.g. clock ticks for cpu.\n", m)
+		fmt.Fprintf(w, "# TYPE procmon_%s_total counter\n", m)
+		fmt.Fprint(w, strings.Join(lines, ""))
+	}
+}
+
+// writePerPid writes the metrics of each member of group targets, with
+// "per_pid": true, as procmon_pid_<metric> so that they don't add up with
+// the sums.
+func writePerPid(w io.Writer, names []string, metrics []string, latest map[string]Sample) {
+	perPid := make(map[string]map[int]map[string]int64)
+	statsLock.RLock()
+	for _, name := range names {
+		if ps := statsMap[name]; ps != nil && ps.PerPid != nil {
+			perPid[name] = ps.PerPid
+		}
+	}
+	statsLock.RUnlock()
+	if len(perPid) == 0 {
+		return
+	}
+	for _, m := range metrics {
+		var lines []string
+		for _, name := range names {
+			if _, ok := latest[name].Metrics[m]; !ok {
+				continue
+			}
+			var pids []int
+			for pid := range perPid[name] {
+				pids = append(pids, pid)
+			}
+			sort.Ints(pids)
+			for _, pid := range pids {
+				if v, ok := perPid[name][pid][m]; ok {
+					lines = append(lines, fmt.Sprintf("procmon_pid_%s{%s,pid=\"%d\"} %d\n", m, promTargetLabels(name), pid, v))
+				}
+			}
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(w, "# TYPE procmon_pid_%s gauge\n", m)
+			fmt.Fprint(w, strings.Join(lines, ""))
+		}
+	}
+}
+
+// promWindow returns the window _avg and _peak cover for req: ?window=,
+// else the scrape timeout Prometheus sends along, else defaultPromWindow.
+// Unlike "since the previous scrape" it is the same for every scraper.
+func promWindow(req *http.Request) (time.Duration, error) {
+	if window := req.URL.Query().Get("window"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("bad window: %s", window)
+		}
+		return d, nil
+	}
+	timeout, err := strconv.ParseFloat(req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err == nil && timeout > 0 {
+		return time.Duration(timeout * float64(time.Second)), nil
+	}
+	return defaultPromWindow, nil
+}
+
+func prometheusHandler(w http.ResponseWriter, req *http.Request) {
+	window, err := promWindow(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WritePrometheus(w, time.Now().Add(-window).UnixNano()/int64(time.Millisecond))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPromWindow(t *testing.T) {
+	tests := []struct {
+		query   string
+		timeout string
+		want    time.Duration
+		bad     bool
+	}{
+		{"", "", defaultPromWindow, false},
+		{"", "14.5", 14500 * time.Millisecond, false},
+		{"?window=15s", "10", 15 * time.Second, false},
+		{"?window=-1s", "", 0, true},
+		{"?window=soon", "", 0, true},
+		{"", "nope", defaultPromWindow, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/prometheus"+tt.query, nil)
+		if tt.timeout != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.timeout)
+		}
+		got, err := promWindow(req)
+		if (err != nil) != tt.bad || got != tt.want {
+			t.Errorf("promWindow(%q, %q) = %v, %v, want %v", tt.query, tt.timeout, got, err, tt.want)
+		}
+	}
+}
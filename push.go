@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InfluxSink pushes every sample as InfluxDB line protocol. Batches the
+// sink does not accept are queued, on disk when QueueDir is set, and sent
+// in order with their original timestamps once it is reachable again.
+type InfluxSink struct {
+	URL           string `json:"url"`
+	QueueDir      string `json:"queue_dir,omitempty"`
+	QueueMaxBytes int64  `json:"queue_max_bytes,omitempty"`
+}
+
+const defaultQueueMaxBytes = 64 << 20
+
+var influxSinks []InfluxSink
+
+// A sink that fails is retried after pushRetryMin, doubling up to
+// pushRetryMax while it keeps failing.
+const (
+	pushRetryMin = time.Second
+	pushRetryMax = time.Minute
+)
+
+// pushQueues has the queue of every configured sink, each drained by its
+// own goroutine so that one unreachable sink doesn't hold up the others.
+var (
+	pushLock   sync.Mutex
+	pushQueues = make(map[InfluxSink]*pushQueue)
+)
+
+var tagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// lineProtocol renders one sample, timestamped in nanoseconds. Tags with
+// empty values are left out and a sample without metrics renders nothing,
+// as InfluxDB rejects the whole batch over either.
+func lineProtocol(processName string, s Sample) []byte {
+	if len(s.Metrics) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	b.WriteString("procmon")
+	writeTag(&b, "process", processName)
+	writeTag(&b, "host", hostname)
+	fmt.Fprintf(&b, ",pid=%d", s.Pid)
+	labels := TargetLabels(processName)
+	for _, k := range labelNames(labels) {
+		writeTag(&b, k, labels[k])
+	}
+	b.WriteByte(' ')
+	names := make([]string, 0, len(s.Metrics))
+	for name := range s.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%di", tagEscaper.Replace(name), s.Metrics[name])
+	}
+	fmt.Fprintf(&b, " %d\n", s.Time*1000000)
+	return b.Bytes()
+}
+
+func writeTag(b *bytes.Buffer, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, ",%s=%s", key, tagEscaper.Replace(value))
+	}
+}
+
+// pushQueue holds the batches not yet accepted by one sink, oldest first.
+// Batches are named by when they were queued, which is also the file name
+// of a batch queued on disk.
+type pushQueue struct {
+	lock sync.Mutex
+	dir  string
+	max  int64
+	mem  []queuedBatch
+	seq  int
+
+	wake chan struct{}
+	stop chan struct{}
+}
+
+type queuedBatch struct {
+	name string
+	data []byte
+}
+
+func newPushQueue(sink InfluxSink) *pushQueue {
+	q := &pushQueue{dir: sink.QueueDir, max: sink.QueueMaxBytes, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	if q.max <= 0 {
+		q.max = defaultQueueMaxBytes
+	}
+	if q.dir != "" {
+		if err := os.MkdirAll(q.dir, 0700); err != nil {
+			fmt.Println("push queue:", err)
+			q.dir = ""
+		}
+	}
+	return q
+}
+
+func (q *pushQueue) segments() []os.FileInfo {
+	files, _ := ioutil.ReadDir(q.dir)
+	var segs []os.FileInfo
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".lp") {
+			segs = append(segs, fi)
+		}
+	}
+	return segs
+}
+
+// add appends a batch, dropping the oldest ones once the queue is over its
+// size limit, and wakes the drain.
+func (q *pushQueue) add(batch []byte) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	defer q.notify()
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.lp", time.Now().UnixNano(), q.seq%1000000)
+	if q.dir == "" {
+		q.mem = append(q.mem, queuedBatch{name, batch})
+		var size int64
+		for i := len(q.mem) - 1; i >= 0; i-- {
+			size += int64(len(q.mem[i].data))
+			if size > q.max {
+				fmt.Println("push queue full, dropped", i+1, "batches")
+				q.mem = q.mem[i+1:]
+				break
+			}
+		}
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(q.dir, name), batch, 0600); err != nil {
+		fmt.Println("push queue:", err)
+		return
+	}
+	segs := q.segments()
+	var size int64
+	for i := len(segs) - 1; i >= 0; i-- {
+		size += segs[i].Size()
+		if size > q.max {
+			fmt.Println("push queue full, dropped", i+1, "batches")
+			for _, fi := range segs[:i+1] {
+				os.Remove(filepath.Join(q.dir, fi.Name()))
+			}
+			break
+		}
+	}
+}
+
+func (q *pushQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest batch, false if the queue is empty.
+func (q *pushQueue) next() (queuedBatch, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.dir == "" {
+		if len(q.mem) == 0 {
+			return queuedBatch{}, false, nil
+		}
+		return q.mem[0], true, nil
+	}
+	segs := q.segments()
+	if len(segs) == 0 {
+		return queuedBatch{}, false, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(q.dir, segs[0].Name()))
+	if err != nil {
+		return queuedBatch{}, false, err
+	}
+	return queuedBatch{segs[0].Name(), data}, true, nil
+}
+
+// remove drops the batch name once sent, unless the queue dropped it
+// meanwhile.
+func (q *pushQueue) remove(name string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.dir == "" {
+		if len(q.mem) > 0 && q.mem[0].name == name {
+			q.mem = q.mem[1:]
+		}
+		return
+	}
+	os.Remove(filepath.Join(q.dir, name))
+}
+
+// errBatchRejected is returned by send for a batch the sink will never
+// accept, e.g. 400 for a malformed line or 413 for one too large.
+var errBatchRejected = errors.New("batch rejected")
+
+// drain sends queued batches oldest first and stops at the first failure
+// so that the order is kept, except for rejected batches, which retrying
+// won't help and which are dropped. The queue isn't locked while sending,
+// so the collector can keep adding.
+func (q *pushQueue) drain(send func([]byte) error) error {
+	for {
+		b, ok, err := q.next()
+		if err != nil || !ok {
+			return err
+		}
+		err = send(b.data)
+		if errors.Is(err, errBatchRejected) {
+			fmt.Println("push: dropped batch:", err)
+		} else if err != nil {
+			return err
+		}
+		q.remove(b.name)
+	}
+}
+
+// run drains the queue into sink whenever batches are added, retrying with
+// exponential backoff while the sink fails, until the sink is removed.
+func (q *pushQueue) run(sink InfluxSink) {
+	backoff := pushRetryMin
+	for {
+		select {
+		case <-q.wake:
+		case <-q.stop:
+			return
+		}
+		for {
+			err := q.drain(sink.send)
+			if err == nil {
+				backoff = pushRetryMin
+				break
+			}
+			fmt.Println("push:", err, "retrying in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-q.stop:
+				return
+			}
+			if backoff *= 2; backoff > pushRetryMax {
+				backoff = pushRetryMax
+			}
+		}
+	}
+}
+
+func (sink InfluxSink) send(batch []byte) error {
+	resp, err := notifyClient.Post(sink.URL, "text/plain; charset=utf-8", bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%s returned %s: %w", sink.URL, resp.Status, errBatchRejected)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s returned %s", sink.URL, resp.Status)
+	}
+	return nil
+}
+
+// queuePush adds the samples of one collection to the queue of every sink
+// without waiting for any of them. Queues of sinks no longer configured are
+// stopped; what they hold on disk is sent if the sink comes back. Callers
+// hold statsLock.
+func queuePush(batch []byte) {
+	pushLock.Lock()
+	defer pushLock.Unlock()
+	keep := make(map[InfluxSink]bool)
+	for _, sink := range influxSinks {
+		keep[sink] = true
+		q := pushQueues[sink]
+		if q == nil {
+			q = newPushQueue(sink)
+			pushQueues[sink] = q
+			// a queue on disk may hold batches of a previous run
+			q.notify()
+			go q.run(sink)
+		}
+		if len(batch) > 0 {
+			q.add(batch)
+		}
+	}
+	for sink, q := range pushQueues {
+		if !keep[sink] {
+			close(q.stop)
+			delete(pushQueues, sink)
+		}
+	}
+}
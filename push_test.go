@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An unreachable sink must neither block the collector nor delay or drop
+// the batches of the other sinks.
+func TestQueuePushUnreachableSink(t *testing.T) {
+	var lock sync.Mutex
+	var got []string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		lock.Lock()
+		got = append(got, string(body))
+		lock.Unlock()
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	old := influxSinks
+	influxSinks = []InfluxSink{{URL: down.URL}, {URL: up.URL}}
+	defer func() {
+		influxSinks = old
+		queuePush(nil)
+	}()
+	const batches = 200
+	start := time.Now()
+	for i := 0; i < batches; i++ {
+		queuePush([]byte{byte('a' + i%26)})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("queueing took %v", d)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		n := len(got)
+		lock.Unlock()
+		if n == batches || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(got) != batches {
+		t.Fatalf("reachable sink got %d of %d batches", len(got), batches)
+	}
+	for i, b := range got {
+		if b != string(rune('a'+i%26)) {
+			t.Fatalf("batch %d is %q, out of order", i, b)
+		}
+	}
+}
+
+func TestDrainDropsRejectedBatches(t *testing.T) {
+	var got []string
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		switch string(body) {
+		case "bad":
+			http.Error(w, "unable to parse", http.StatusBadRequest)
+		case "slow":
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			got = append(got, string(body))
+		}
+	}))
+	defer sink.Close()
+	q := newPushQueue(InfluxSink{URL: sink.URL})
+	for _, b := range []string{"a", "bad", "b", "slow", "c"} {
+		q.add([]byte(b))
+	}
+	send := InfluxSink{URL: sink.URL}.send
+	if err := q.drain(send); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("drain = %v, want to stop at the 429", err)
+	}
+	if strings.Join(got, ",") != "a,b" {
+		t.Errorf("sent %v, want the batches around the rejected one", got)
+	}
+	if b, _, _ := q.next(); string(b.data) != "slow" {
+		t.Errorf("next batch %q, want the one to retry", b.data)
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	oldHost := hostname
+	hostname = ""
+	defer func() { hostname = oldHost }()
+	if got := lineProtocol("nginx", Sample{Time: 1, Pid: 7}); got != nil {
+		t.Errorf("sample without metrics = %q", got)
+	}
+	got := string(lineProtocol("my app", Sample{Time: 1, Pid: 7, Metrics: map[string]int64{"rss": 4096}}))
+	if want := "procmon,process=my\\ app,pid=7 rss=4096i 1000000\n"; got != want {
+		t.Errorf("lineProtocol = %q, want %q", got, want)
+	}
+}
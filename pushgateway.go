@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PushgatewayConfig pushes what /prometheus serves to a Prometheus
+// Pushgateway every Interval and once more on shutdown, grouped by Job
+// (default "procmon") and Instance (default the host name).
+type PushgatewayConfig struct {
+	URL      string `json:"url"`
+	Job      string `json:"job,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Interval string `json:"interval,omitempty"`
+
+	interval time.Duration
+}
+
+var pushgateway *PushgatewayConfig
+
+func (pg *PushgatewayConfig) setDefaults() error {
+	if u, err := url.Parse(pg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("bad pushgateway url %q", pg.URL)
+	}
+	if pg.Job == "" {
+		pg.Job = "procmon"
+	}
+	if pg.Instance == "" {
+		pg.Instance = hostname
+	}
+	pg.interval = 15 * time.Second
+	if pg.Interval != "" {
+		d, err := time.ParseDuration(pg.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("bad pushgateway interval %q", pg.Interval)
+		}
+		pg.interval = d
+	}
+	return nil
+}
+
+// groupingPath returns the /metrics/job/<job>/instance/<instance> path,
+// base64 encoding values the path can't hold.
+func (pg *PushgatewayConfig) groupingPath() string {
+	path := "/metrics"
+	for _, kv := range [][2]string{{"job", pg.Job}, {"instance", pg.Instance}} {
+		if strings.Contains(kv[1], "/") {
+			path += "/" + kv[0] + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(kv[1]))
+		} else {
+			path += "/" + kv[0] + "/" + url.PathEscape(kv[1])
+		}
+	}
+	return strings.TrimSuffix(pg.URL, "/") + path
+}
+
+var lastGatewayPush int64
+
+// pushToGateway replaces the metrics of the group with the current ones.
+func pushToGateway(pg *PushgatewayConfig) error {
+	var b bytes.Buffer
+	since := lastGatewayPush
+	lastGatewayPush = time.Now().UnixNano() / int64(time.Millisecond)
+	WritePrometheus(&b, since)
+	req, err := http.NewRequest(http.MethodPut, pg.groupingPath(), &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", pg.URL, resp.Status)
+	}
+	return nil
+}
+
+// RunPushgateway pushes to the configured Pushgateway until stop is closed,
+// and then a last time so short runs end up in Prometheus.
+func RunPushgateway(stop chan struct{}) {
+	next := time.Now()
+	for {
+		statsLock.RLock()
+		pg := pushgateway
+		statsLock.RUnlock()
+		select {
+		case <-stop:
+			if pg != nil {
+				if err := pushToGateway(pg); err != nil {
+					fmt.Println("pushgateway:", err)
+				}
+			}
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if pg == nil {
+			next = time.Now().Add(time.Second)
+			continue
+		}
+		if err := pushToGateway(pg); err != nil {
+			fmt.Println("pushgateway:", err)
+		}
+		next = time.Now().Add(pg.interval)
+	}
+}
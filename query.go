@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Query selects one metric of one process over the last Range, averaged
+// into Step sized buckets. An empty Range means everything retained and an
+// empty Step returns the raw samples. From and To (unix ms) further limit
+// the samples when set. Encoding "delta" rounds values to integers and
+// gives every point after the first as the difference to the previous one,
+// which keeps the JSON short.
+type Query struct {
+	Process  string `json:"process"`
+	Metric   string `json:"metric"`
+	Range    string `json:"range,omitempty"`
+	Step     string `json:"step,omitempty"`
+	From     int64  `json:"from,omitempty"`
+	To       int64  `json:"to,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type QueryResult struct {
+	Query
+	// Points are [unix ms, value] pairs.
+	Points [][2]float64 `json:"points"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func RunQuery(q Query) QueryResult {
+	result := QueryResult{Query: q, Points: [][2]float64{}}
+	var rng, step time.Duration
+	var err error
+	if q.Range != "" {
+		if rng, err = time.ParseDuration(q.Range); err != nil {
+			result.Error = "bad range: " + err.Error()
+			return result
+		}
+	}
+	if q.Encoding != "" && q.Encoding != "delta" {
+		result.Error = "bad encoding: " + q.Encoding
+		return result
+	}
+	if q.Step != "" {
+		if step, err = time.ParseDuration(q.Step); err != nil || step <= 0 {
+			result.Error = "bad step: " + q.Step
+			return result
+		}
+	}
+
+	statsLock.RLock()
+	ps, ok := statsMap[q.Process]
+	var samples []Sample
+	if ok {
+		samples = ps.Samples.Slice()
+	}
+	statsLock.RUnlock()
+	if _, _, federated := federatedTarget(q.Process); !ok && federated {
+		samples, ok = GetFederatedMetrics()[q.Process], true
+	}
+	if !ok {
+		result.Error = fmt.Sprintf("unknown process %q", q.Process)
+		return result
+	}
+
+	from := q.From
+	if rng > 0 {
+		if f := currentTime().Add(-rng).UnixNano() / int64(time.Millisecond); f > from {
+			from = f
+		}
+	}
+	stepMs := int64(step / time.Millisecond)
+	var bucket int64
+	var sum float64
+	var n int
+	flush := func() {
+		if n > 0 {
+			result.Points = append(result.Points, [2]float64{float64(bucket), sum / float64(n)})
+		}
+		sum, n = 0, 0
+	}
+	for _, s := range samples {
+		v, ok := s.Metrics[q.Metric]
+		if !ok || s.Time < from || (q.To > 0 && s.Time > q.To) {
+			continue
+		}
+		if stepMs == 0 {
+			result.Points = append(result.Points, [2]float64{float64(s.Time), float64(v)})
+			continue
+		}
+		b := s.Time - s.Time%stepMs
+		if b != bucket {
+			flush()
+			bucket = b
+		}
+		sum += float64(v)
+		n++
+	}
+	flush()
+	if q.Encoding == "delta" {
+		var prev [2]float64
+		for i, p := range result.Points {
+			p[1] = math.Round(p[1])
+			result.Points[i] = [2]float64{p[0] - prev[0], p[1] - prev[1]}
+			prev = p
+		}
+	}
+	return result
+}
+
+// queryHandler answers GET /api/v1/query?process=&metric=&range=&step= with
+// a single QueryResult, and POST {"queries": [...]} with {"results": [...]}.
+func queryHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+		v := req.URL.Query()
+		json.NewEncoder(w).Encode(RunQuery(Query{
+			Process:  v.Get("process"),
+			Metric:   v.Get("metric"),
+			Range:    v.Get("range"),
+			Step:     v.Get("step"),
+			Encoding: v.Get("encoding"),
+		}))
+	case http.MethodPost:
+		var body struct {
+			Queries []Query `json:"queries"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results := make([]QueryResult, 0, len(body.Queries))
+		for _, q := range body.Queries {
+			results = append(results, RunQuery(q))
+		}
+		json.NewEncoder(w).Encode(map[string][]QueryResult{"results": results})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit is the number of requests per second allowed per client IP,
+// with bursts of twice that; 0 disables the limit.
+var rateLimit float64
+
+// expensiveSlots caps the concurrent expensive requests (range queries,
+// exports, summaries, fd listings and the dependency map). nil means no
+// cap.
+var expensiveSlots chan struct{}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	rateLock    sync.Mutex
+	rateBuckets = make(map[string]*tokenBucket)
+	ratePruned  time.Time
+)
+
+// allowRequest takes a token from the bucket of ip, reporting false and
+// how long until the next one when it is empty.
+func allowRequest(ip string, now time.Time) (bool, time.Duration) {
+	burst := math.Max(1, 2*rateLimit)
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	if now.Sub(ratePruned) > time.Minute {
+		// a full bucket is the same as none
+		for k, b := range rateBuckets {
+			if now.Sub(b.last).Seconds()*rateLimit+b.tokens >= burst {
+				delete(rateBuckets, k)
+			}
+		}
+		ratePruned = now
+	}
+	b := rateBuckets[ip]
+	if b == nil {
+		b = &tokenBucket{tokens: burst, last: now}
+		rateBuckets[ip] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rateLimit * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// limitRate answers 429 to clients going over -rate-limit.
+func limitRate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if rateLimit <= 0 {
+			h.ServeHTTP(w, req)
+			return
+		}
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if ok, wait := allowRequest(ip, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// limitExpensive answers 503 when -max-expensive-requests requests are
+// already running, rather than queueing them.
+func limitExpensive(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if expensiveSlots == nil {
+			h(w, req)
+			return
+		}
+		select {
+		case expensiveSlots <- struct{}{}:
+			defer func() { <-expensiveSlots }()
+			h(w, req)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	}
+}
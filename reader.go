@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// The /proc files of other users' processes that need CAP_SYS_PTRACE or
+// CAP_DAC_READ_SEARCH, or that hidepid hides, are read through these
+// functions. With -reader-socket they are fetched from the privileged
+// "reader" helper instead, so the exporter itself can run unprivileged.
+
+type readerRequest struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+type readerResponse struct {
+	Data  []byte   `json:"data,omitempty"`
+	Names []string `json:"names,omitempty"`
+	Link  string   `json:"link,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// Errno is the errno of Error, if any, so that the exporter sees the
+	// same error it would reading the file itself, e.g. a permission error
+	// leaving one metric out rather than failing the sample.
+	Errno int `json:"errno,omitempty"`
+}
+
+var (
+	readerSocket string
+	readerLock   sync.Mutex
+	readerConn   net.Conn
+	readerDec    *json.Decoder
+)
+
+func readerCall(op string, path string) (readerResponse, error) {
+	var resp readerResponse
+	readerLock.Lock()
+	defer readerLock.Unlock()
+	if readerConn == nil {
+		conn, err := net.Dial("unix", readerSocket)
+		if err != nil {
+			return resp, err
+		}
+		readerConn, readerDec = conn, json.NewDecoder(conn)
+	}
+	req := readerRequest{Op: op, Path: strings.TrimPrefix(strings.TrimPrefix(path, procRoot), "/")}
+	err := json.NewEncoder(readerConn).Encode(req)
+	if err == nil {
+		err = readerDec.Decode(&resp)
+	}
+	if err != nil {
+		// start over with a new connection next time
+		readerConn.Close()
+		readerConn = nil
+		return resp, err
+	}
+	if resp.Errno != 0 {
+		return resp, &os.PathError{Op: op, Path: path, Err: syscall.Errno(resp.Errno)}
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func readProcFile(path string) ([]byte, error) {
+	if readerSocket == "" {
+		return ioutil.ReadFile(path)
+	}
+	resp, err := readerCall("read", path)
+	return resp.Data, err
+}
+
+func readProcDir(path string) ([]string, error) {
+	if readerSocket == "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.Readdirnames(-1)
+	}
+	resp, err := readerCall("readdir", path)
+	return resp.Names, err
+}
+
+func readProcLink(path string) (string, error) {
+	if readerSocket == "" {
+		return os.Readlink(path)
+	}
+	resp, err := readerCall("readlink", path)
+	return resp.Link, err
+}
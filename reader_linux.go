@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// readerAllowed lists, per operation, the paths below /proc the helper
+// serves. Anything else, environ in particular, is refused.
+var readerAllowed = map[string]*regexp.Regexp{
+	"read":     regexp.MustCompile(`^([0-9]+/(fdinfo/[0-9]+|maps|stat|statm|status|cmdline|cgroup|io|limits|oom_score|oom_score_adj|smaps_rollup|numa_maps)|([0-9]+/)?net/(tcp|udp)6?)$`),
+	"readdir":  regexp.MustCompile(`^([0-9]+/fd)?$`),
+	"readlink": regexp.MustCompile(`^[0-9]+/(fd/[0-9]+|exe)$`),
+}
+
+func serveReaderRequest(req readerRequest) readerResponse {
+	var resp readerResponse
+	re := readerAllowed[req.Op]
+	if re == nil || !re.MatchString(req.Path) {
+		resp.Error = fmt.Sprintf("%s %s: not allowed", req.Op, req.Path)
+		resp.Errno = int(syscall.EACCES)
+		return resp
+	}
+	path := strings.TrimSuffix(procRoot+"/"+req.Path, "/")
+	var err error
+	switch req.Op {
+	case "read":
+		resp.Data, err = ioutil.ReadFile(path)
+	case "readdir":
+		var f *os.File
+		if f, err = os.Open(path); err == nil {
+			resp.Names, err = f.Readdirnames(-1)
+			f.Close()
+		}
+	case "readlink":
+		resp.Link, err = os.Readlink(path)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			resp.Errno = int(errno)
+		}
+	}
+	return resp
+}
+
+func peerUID(conn net.Conn) (int, error) {
+	raw, err := conn.(*net.UnixConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
+
+func serveReaderConn(conn net.Conn, allowUID int) {
+	defer conn.Close()
+	uid, err := peerUID(conn)
+	if err != nil || uid != allowUID {
+		fmt.Println("reader: refused connection from uid", uid, err)
+		return
+	}
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req readerRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(serveReaderRequest(req)); err != nil {
+			return
+		}
+	}
+}
+
+// RunReader implements the reader subcommand: the privileged helper that
+// serves restricted /proc files to an exporter started with -reader-socket.
+func RunReader(args []string) int {
+	fs := flag.NewFlagSet("reader", flag.ExitOnError)
+	socket := fs.String("socket", "/run/procmon/reader.sock", "Unix socket to serve on.")
+	allowUID := fs.Int("allow-uid", -1, "Only serve connections from this uid (the exporter's user), required.")
+	root := fs.String("proc-root", procRoot, procRootUsage)
+	fs.Parse(args)
+	procRoot = *root
+	if *allowUID < 0 {
+		fmt.Fprintln(os.Stderr, "reader: -allow-uid is required")
+		return 2
+	}
+
+	os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	check(os.Chmod(*socket, 0660))
+	fmt.Println("reader serving", *socket)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		go serveReaderConn(conn, *allowUID)
+	}
+}
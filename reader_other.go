@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func RunReader(args []string) int {
+	fmt.Fprintln(os.Stderr, "the reader helper is only available on Linux")
+	return 1
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"os"
+	"time"
+)
+
+// A Cycle is one line of a -record file: the samples of one collection.
+type Cycle struct {
+	Time       int64             `json:"time"`
+	IntervalMs int64             `json:"interval_ms"`
+	Samples    map[string]Sample `json:"samples"`
+}
+
+var (
+	recordFile   *os.File
+	recordWriter *bufio.Writer
+	// replayEnd is the time of the last cycle when serving a -replay file,
+	// used in place of the current time.
+	replayEnd time.Time
+)
+
+// StartRecording appends every collection cycle to filename.
+func StartRecording(filename string) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	recordFile, recordWriter = f, bufio.NewWriter(f)
+	onShutdown(func() {
+		statsLock.Lock()
+		defer statsLock.Unlock()
+		recordWriter.Flush()
+		recordFile.Close()
+		recordWriter = nil
+	})
+	return nil
+}
+
+// recordCycle is called by collectAll with statsLock held.
+func recordCycle(samples map[string]Sample) {
+	if recordWriter == nil || len(samples) == 0 {
+		return
+	}
+	line, _ := json.Marshal(Cycle{
+		Time:       time.Now().UnixNano() / int64(time.Millisecond),
+		IntervalMs: int64(collectInterval / time.Millisecond),
+		Samples:    samples,
+	})
+	recordWriter.Write(append(line, '\n'))
+	if err := recordWriter.Flush(); err != nil {
+		fmt.Println("record:", err)
+	}
+}
+
+// LoadReplay fills the sample history from a -record file instead of
+// collecting, keeping every sample in it.
+func LoadReplay(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	history := make(map[string]*ProcessStats)
+	samples := make(map[string][]Sample)
+	var last Cycle
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var c Cycle
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		for name, s := range c.Samples {
+			ps := history[name]
+			if ps == nil {
+				ps = &ProcessStats{}
+				history[name] = ps
+			}
+			samples[name] = append(samples[name], s)
+			ps.Pids = []int{s.Pid}
+			ps.LastScrape = time.Unix(0, s.Time*int64(time.Millisecond))
+		}
+		last = c
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return fmt.Errorf("%s: no samples", filename)
+	}
+
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	statsMap = history
+	for name, ps := range history {
+		ps.Samples = procmon.NewRing(samples[name])
+		if len(samples[name]) > maxSamples {
+			maxSamples = len(samples[name])
+		}
+		for _, s := range samples[name] {
+			for name := range s.Metrics {
+				knownMetrics[name] = true
+			}
+		}
+	}
+	if last.IntervalMs > 0 {
+		collectInterval = time.Duration(last.IntervalMs) * time.Millisecond
+	}
+	replayEnd = time.Unix(0, last.Time*int64(time.Millisecond))
+	return nil
+}
+
+// currentTime is now, or the end of the recording when replaying.
+func currentTime() time.Time {
+	if !replayEnd.IsZero() {
+		return replayEnd
+	}
+	return time.Now()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ResolveTarget returns the pids currently matched by a target. A target
+// is an executable name, "pgid:<id>" for every member of a process group,
+// "sid:<id>" for every member of a session, "container:<name>" for the
+// main process of a Docker container, "unit:<name>" for the main process
+// of a systemd unit, "user:<name or uid>" for every process of a user or
+// "group:<name>" for the members of a group of the config, see groups.go.
+func ResolveTarget(target string) []int {
+	switch {
+	case strings.HasPrefix(target, "group:"):
+		return groupPids(strings.TrimPrefix(target, "group:"))
+	case strings.HasPrefix(target, "user:"):
+		uid, err := lookupUid(strings.TrimPrefix(target, "user:"))
+		if err != nil {
+			return nil
+		}
+		s := currentScan()
+		return s.find(func(e procEntry) bool { return s.uid(e) == uid })
+	case strings.HasPrefix(target, "unit:"):
+		return unitPids(strings.TrimPrefix(target, "unit:"))
+	case strings.HasPrefix(target, "container:"):
+		return containerPids(strings.TrimPrefix(target, "container:"))
+	case strings.HasPrefix(target, "pgid:"):
+		pgid := strings.TrimPrefix(target, "pgid:")
+		return currentScan().find(func(e procEntry) bool { return e.pgrp == pgid })
+	case strings.HasPrefix(target, "sid:"):
+		sid := strings.TrimPrefix(target, "sid:")
+		return currentScan().find(func(e procEntry) bool { return e.session == sid })
+	}
+	return FindPids(target)
+}
+
+// isGroupTarget reports whether all pids of the target are aggregated
+// rather than just the first match.
+func isGroupTarget(target string) bool {
+	return strings.HasPrefix(target, "pgid:") || strings.HasPrefix(target, "sid:") ||
+		strings.HasPrefix(target, "user:") || strings.HasPrefix(target, "group:")
+}
+
+// lookupUid resolves a user name or numeric uid.
+func lookupUid(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGid resolves a group name or numeric gid.
+func lookupGid(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
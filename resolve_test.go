@@ -0,0 +1,99 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useTestProc points the collector at testdata/proc, a synthetic proc(5)
+// tree, for the rest of the test.
+func useTestProc(t *testing.T) {
+	oldRoot, oldBoot := procRoot, bootTime
+	procRoot, bootTime = "testdata/proc", 0
+	lastScan = nil
+	t.Cleanup(func() {
+		procRoot, bootTime = oldRoot, oldBoot
+		lastScan = nil
+	})
+}
+
+func TestResolveTarget(t *testing.T) {
+	useTestProc(t)
+	tests := []struct {
+		target string
+		want   []int
+	}{
+		{"nginx", []int{100, 101}},
+		{"my (odd) proc", []int{200}},
+		{"very-long-proce", []int{300}},
+		{"very-long-process-name", []int{300}},
+		{"very-long-process-other", nil},
+		{"missing", nil},
+		{"pgid:100", []int{100, 101}},
+		{"sid:200", []int{200, 300}},
+		{"sid:1", nil},
+		{"user:1000", []int{200, 300}},
+		{"user:33", []int{101}},
+	}
+	for _, tt := range tests {
+		if got := ResolveTarget(tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ResolveTarget(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPidStat(t *testing.T) {
+	useTestProc(t)
+	f, err := pidStat(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comm := string(f.Bytes(1)); comm != "my (odd) proc" {
+		t.Errorf("comm = %q", comm)
+	}
+	for i, want := range map[int]int64{3: 1, 13: 10, 14: 5, 19: 3, 21: 6000, 22: 20971520} {
+		if got := f.Int(i); got != want {
+			t.Errorf("field %d = %d, want %d", i, got, want)
+		}
+	}
+	if _, err := pidStat(400); err == nil {
+		t.Error("pidStat of a truncated stat succeeded")
+	}
+	if _, err := pidStat(999); err == nil {
+		t.Error("pidStat of a missing pid succeeded")
+	}
+	if got, want := GetStartTime(f.Int(21)), time.Unix(1700000060, 0); !got.Equal(want) {
+		t.Errorf("start time %v, want %v", got, want)
+	}
+}
+
+func TestPidStatus(t *testing.T) {
+	useTestProc(t)
+	st, err := pidStatus(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int64{
+		statusVoluntaryCtxt:    150,
+		statusNonvoluntaryCtxt: 7,
+		statusVmHWM:            2048 * 1024,
+		statusVmPeak:           20480 * 1024,
+		statusVmSwap:           0,
+		statusThreads:          1,
+	}
+	for field, v := range want {
+		if !st.found[field] || st.values[field] != v {
+			t.Errorf("field %d = %d (found %v), want %d", field, st.values[field], st.found[field], v)
+		}
+	}
+	if st.cpusAllowed != "0-3" {
+		t.Errorf("Cpus_allowed_list = %q", st.cpusAllowed)
+	}
+	if _, err := statusValue(400, statusThreads); err == nil {
+		t.Error("statusValue without a status file succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type RuntimeInfo struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version,omitempty"`
+	Labels  map[string]string `json:"labels"`
+	Metrics []string          `json:"metrics"`
+	Hints   []string          `json:"hints,omitempty"`
+}
+
+type runtimePreset struct {
+	Metrics []string
+	Hints   []string
+}
+
+var runtimePresets = map[string]runtimePreset{
+	"go": {
+		Metrics: []string{"cpu", "rss", "threads", "epoll_watches"},
+		Hints: []string{
+			"GC and goroutine counts are only visible from inside the process: scrape its expvar (/debug/vars) or Prometheus endpoint",
+			"rss includes memory the Go runtime has not yet returned to the OS",
+		},
+	},
+	"jvm": {
+		Metrics: []string{"cpu", "rss", "vsize", "threads"},
+		Hints: []string{
+			"rss covers heap, metaspace, code cache and thread stacks; compare with -Xmx",
+			"use jmx_exporter or the JVM's JMX port for GC and heap pool metrics",
+			"send SIGQUIT for a thread dump",
+		},
+	},
+	"python": {
+		Metrics: []string{"cpu", "rss", "threads"},
+		Hints: []string{
+			"the GIL keeps cpu near one core per process; watch each worker process",
+		},
+	},
+	"node": {
+		Metrics: []string{"cpu", "rss", "threads", "epoll_watches"},
+		Hints: []string{
+			"event loop lag is only visible from inside the process: expose it via prom-client",
+		},
+	},
+}
+
+// goBuildVersion returns the toolchain version recorded in the
+// .go.buildinfo section of a Go executable, or "" if it is not one.
+func goBuildVersion(f *elf.File) (string, bool) {
+	sect := f.Section(".go.buildinfo")
+	if sect == nil {
+		return "", false
+	}
+	data, err := sect.Data()
+	if err != nil || len(data) < 32 || !bytes.HasPrefix(data, []byte("\xff Go buildinf:")) {
+		return "", true
+	}
+	// Since go1.18 the version follows the 32 byte header as a varint
+	// length prefixed string; older binaries store pointers instead.
+	if data[15]&2 == 0 {
+		return "", true
+	}
+	n, w := binary.Uvarint(data[32:])
+	if w <= 0 || uint64(len(data)-32-w) < n {
+		return "", true
+	}
+	return string(data[32+w : 32+w+int(n)]), true
+}
+
+func DetectRuntime(pid int) *RuntimeInfo {
+	procExe := procRoot + "/" + strconv.Itoa(pid) + "/exe"
+	exe, _ := readProcLink(procExe)
+	base := filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+
+	var name, version string
+	if f, err := elf.Open(procExe); err == nil {
+		if v, ok := goBuildVersion(f); ok {
+			name, version = "go", v
+		}
+		f.Close()
+	}
+	if name == "" {
+		libs, _ := GetLibraries(pid)
+		for _, lib := range libs {
+			switch {
+			case strings.HasSuffix(lib, "/libjvm.so"):
+				name = "jvm"
+			case strings.Contains(filepath.Base(lib), "libpython"):
+				name = "python"
+				// libpython3.11.so.1.0
+				version = strings.TrimPrefix(filepath.Base(lib), "libpython")
+				version = strings.Split(version, ".so")[0]
+			case strings.Contains(filepath.Base(lib), "libnode.so"):
+				name = "node"
+			}
+		}
+	}
+	if name == "" {
+		switch {
+		case base == "java":
+			name = "jvm"
+		case strings.HasPrefix(base, "python"):
+			name, version = "python", strings.TrimPrefix(base, "python")
+		case base == "node" || base == "nodejs":
+			name = "node"
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	preset := runtimePresets[name]
+	info := &RuntimeInfo{
+		Name:    name,
+		Version: version,
+		Labels:  map[string]string{"runtime": name},
+		Metrics: preset.Metrics,
+		Hints:   preset.Hints,
+	}
+	if version != "" {
+		info.Labels["runtime_version"] = version
+	}
+	return info
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// procEntry is a process of the process table. state, pgrp and session are
+// empty where the platform has no such thing.
+type procEntry struct {
+	pid     int
+	ppid    int
+	comm    string
+	state   string
+	pgrp    string
+	session string
+}
+
+// procScan is one pass over the process table, shared by every target
+// resolved in a collection cycle rather than walking the table per target.
+type procScan struct {
+	entries []procEntry
+	// live is set for the proc connector table, where state may be stale
+	live bool
+
+	// argv0 and uids cache the reads only some targets need
+	lock  sync.Mutex
+	argv0 map[int]string
+	uids  map[int]int
+}
+
+func (s *procScan) find(match func(e procEntry) bool) []int {
+	var pids []int
+	for _, e := range s.entries {
+		if match(e) {
+			pids = append(pids, e.pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// scanMaxAge is how long a scan is reused outside of a collection cycle,
+// e.g. by bursts and API requests.
+const scanMaxAge = time.Second
+
+var (
+	scanLock  sync.Mutex
+	cycleScan *procScan
+	// lastScan is the latest scan, taken at lastScanTime
+	lastScan     *procScan
+	lastScanTime time.Time
+)
+
+// beginCycleScan makes every target resolved until endCycleScan use the
+// same scan of the process table.
+func beginCycleScan() {
+	s := scanProcesses()
+	scanLock.Lock()
+	cycleScan = s
+	lastScan, lastScanTime = s, time.Now()
+	scanLock.Unlock()
+}
+
+// expireScan makes currentScan scan the process table again outside of a
+// collection cycle, for a caller that can't act on a pid up to scanMaxAge
+// old.
+func expireScan() {
+	scanLock.Lock()
+	lastScan = nil
+	scanLock.Unlock()
+}
+
+func endCycleScan() {
+	scanLock.Lock()
+	cycleScan = nil
+	scanLock.Unlock()
+}
+
+// currentScan returns the scan of the running collection cycle, outside of
+// a cycle the latest scan if it is younger than scanMaxAge, or else a new
+// one.
+func currentScan() *procScan {
+	scanLock.Lock()
+	defer scanLock.Unlock()
+	if cycleScan != nil {
+		return cycleScan
+	}
+	if lastScan == nil || time.Since(lastScanTime) >= scanMaxAge {
+		lastScan, lastScanTime = scanProcesses(), time.Now()
+	}
+	return lastScan
+}
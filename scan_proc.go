@@ -0,0 +1,102 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commLen is the length the kernel truncates comm to.
+const commLen = 15
+
+func scanProcesses() *procScan {
+	if s := liveScan(); s != nil {
+		return s
+	}
+	return walkProcesses()
+}
+
+// walkProcesses reads the stat of every process in /proc.
+func walkProcesses() *procScan {
+	s := &procScan{argv0: make(map[int]string), uids: make(map[int]int)}
+	names, err := readProcDir(procRoot)
+	if err != nil {
+		return s
+	}
+	buf := procBuffers.Get().(*[]byte)
+	defer procBuffers.Put(buf)
+	var f procFields
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		if e, ok := readProcEntry(pid, buf, &f); ok {
+			s.entries = append(s.entries, e)
+		}
+	}
+	return s
+}
+
+// readProcEntry reads the table entry of pid from its stat, with buf and f
+// as scratch space.
+func readProcEntry(pid int, buf *[]byte, f *procFields) (procEntry, bool) {
+	path := procRoot + "/" + strconv.Itoa(pid) + "/stat"
+	var dat []byte
+	var err error
+	if readerSocket == "" {
+		dat, err = readFileInto(path, buf)
+	} else {
+		dat, err = readProcFile(path)
+	}
+	if err != nil {
+		return procEntry{}, false
+	}
+	f.splitStat(dat)
+	if f.Len() < 6 {
+		return procEntry{}, false
+	}
+	// one string for comm to session, the buffer is reused
+	base := f.pos[1][0]
+	kept := string(dat[base:f.pos[5][1]])
+	field := func(i int) string { return kept[f.pos[i][0]-base : f.pos[i][1]-base] }
+	return procEntry{pid: pid, ppid: int(f.Int(3)), comm: field(1), state: field(2), pgrp: field(4), session: field(5)}, true
+}
+
+// matchesName reports whether e runs the executable name. comm only holds
+// the first 15 bytes, so longer names are compared with argv[0] of cmdline.
+func (s *procScan) matchesName(e procEntry, name string) bool {
+	if len(name) <= commLen || e.comm != name[:commLen] {
+		return e.comm == name
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	argv0, ok := s.argv0[e.pid]
+	if !ok {
+		dat, _ := readProcFile(procRoot + "/" + strconv.Itoa(e.pid) + "/cmdline")
+		argv0 = filepath.Base(strings.SplitN(string(dat), "\x00", 2)[0])
+		s.argv0[e.pid] = argv0
+	}
+	return argv0 == name
+}
+
+// uid returns the real uid of e from its status, -1 if it has exited.
+func (s *procScan) uid(e procEntry) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	uid, ok := s.uids[e.pid]
+	if !ok {
+		uid = -1
+		dat, _ := readProcFile(procRoot + "/" + strconv.Itoa(e.pid) + "/status")
+		for _, line := range strings.Split(string(dat), "\n") {
+			if f := strings.Fields(line); len(f) > 1 && f[0] == "Uid:" {
+				uid, _ = strconv.Atoi(f[1])
+			}
+		}
+		s.uids[e.pid] = uid
+	}
+	return uid
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func scanProcesses() *procScan {
+	s := &procScan{}
+	h, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return s
+	}
+	defer syscall.CloseHandle(h)
+	var e syscall.ProcessEntry32
+	e.Size = uint32(unsafe.Sizeof(e))
+	for err = syscall.Process32First(h, &e); err == nil; err = syscall.Process32Next(h, &e) {
+		s.entries = append(s.entries, procEntry{pid: int(e.ProcessID), ppid: int(e.ParentProcessID), comm: syscall.UTF16ToString(e.ExeFile[:])})
+	}
+	return s
+}
+
+// matchesName reports whether e runs the executable name, e.g. "app.exe".
+func (s *procScan) matchesName(e procEntry, name string) bool {
+	return e.comm == name
+}
+
+// uid is not supported on Windows, user targets match nothing.
+func (s *procScan) uid(e procEntry) int {
+	return -1
+}
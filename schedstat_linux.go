@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sched_delay is the time the threads of a process spent runnable but
+// waiting on a run queue, in nanoseconds; it grows when the process is
+// starved for CPU. A thread exiting makes the sum go back, which counts as
+// a reset for that interval.
+func init() {
+	procmon.Register(procmon.Metric{MetricName: "sched_delay", Files: []string{"task/"}, Counter: true, Read: GetSchedDelay})
+}
+
+// GetSchedDelay sums the run queue wait of every thread of pid from
+// task/<tid>/schedstat.
+func GetSchedDelay(pid int) (int64, error) {
+	dir := procRoot + "/" + strconv.Itoa(pid) + "/task"
+	tids, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, tid := range tids {
+		dat, err := ioutil.ReadFile(dir + "/" + tid.Name() + "/schedstat")
+		if os.IsNotExist(err) && total == 0 && tid.Name() == strconv.Itoa(pid) {
+			// kernel without CONFIG_SCHED_INFO
+			return 0, procmon.ErrUnavailable
+		}
+		if err != nil {
+			// the thread exited
+			continue
+		}
+		if s := strings.Fields(string(dat)); len(s) >= 2 {
+			total += atoi64(s[1])
+		}
+	}
+	return total, nil
+}
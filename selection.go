@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+type MetricSelection struct {
+	// Selected is empty when every metric is collected.
+	Selected  []string `json:"selected"`
+	Available []string `json:"available"`
+	// Meta describes how to display each available metric.
+	Meta map[string]MetricMeta `json:"meta"`
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func GetMetricSelection() MetricSelection {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	meta := make(map[string]MetricMeta)
+	for name := range knownMetrics {
+		meta[name] = GetMetricMeta(name)
+	}
+	return MetricSelection{
+		Selected:  sortedKeys(selectedMetrics),
+		Available: sortedKeys(knownMetrics),
+		Meta:      meta,
+	}
+}
+
+// SelectMetrics replaces the selected metrics from the next collection on;
+// an empty list selects every metric.
+func SelectMetrics(names []string) error {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	if len(names) == 0 {
+		selectedMetrics = nil
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, name := range names {
+		if len(knownMetrics) > 0 && !knownMetrics[name] {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+		selected[name] = true
+	}
+	selectedMetrics = selected
+	return nil
+}
+
+// metricSelectionHandler serves /api/v1/metrics: GET returns the selected
+// and available metrics, PUT {"metrics": [...]} changes the selection.
+func metricSelectionHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Metrics []string `json:"metrics"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := SelectMetrics(body.Metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(GetMetricSelection())
+}
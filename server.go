@@ -1,10 +1,33 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+var shutdownHooks []func()
+
+// onShutdown registers f to run after the HTTP server has drained and the
+// collector has stopped, e.g. to flush files.
+func onShutdown(f func()) {
+	shutdownHooks = append(shutdownHooks, f)
+}
+
+func runShutdownHooks() {
+	for _, f := range shutdownHooks {
+		f()
+	}
+}
+
 func hello(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprintf(w, "hello\n")
@@ -18,17 +41,260 @@ func headers(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 }
-func mainPage(w http.ResponseWriter, req *http.Request) {
 
-	fmt.Fprintf(w, "main page\n")
+func inventory(w http.ResponseWriter, req *http.Request) {
+	result := []Inventory{}
+	process := req.URL.Query().Get("process")
+	for _, name := range TargetNames() {
+		if process != "" && name != process {
+			continue
+		}
+		result = append(result, GetInventory(name))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func reload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ReloadConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "configuration reloaded")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		os.Exit(RunConformance(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(RunDoctor(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reader" {
+		os.Exit(RunReader(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "grafana-dashboard" {
+		os.Exit(RunGrafanaDashboard(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "telegraf" {
+		os.Exit(RunTelegraf(os.Args[2:]))
+	}
+	var names = flag.String("name", "python2", "Comma separated process names to monitor.")
+	var cfgFile = flag.String("config", "", "JSON config file with processes, alert rules and webhooks.")
+	var authUser = flag.String("auth-user", "", "Require HTTP basic auth with this user name.")
+	var authPasswordFile = flag.String("auth-password-file", "", "File containing the password for -auth-user.")
+	var htpasswdFile = flag.String("auth-htpasswd", "", "htpasswd file with users allowed to log in (bcrypt, $apr1$, {SHA} or plain text passwords).")
+	var tokenFile = flag.String("auth-token-file", "", "File with bearer tokens, one per line, accepted for reads of /metrics, /prometheus and /api/v1/.")
+	var showVersion = flag.Bool("version", false, "Print version information and exit.")
+	var enablePprof = flag.Bool("enable-pprof", false, "Serve net/http/pprof profiles on -pprof-address.")
+	var enableFdsAPI = flag.Bool("enable-fds-api", false, "Serve the open files of targets on /api/v1/processes/<target>/fds.")
+	var pprofAddress = flag.String("pprof-address", "localhost:6060", "Listen address for the pprof endpoints.")
+	var procRootFlag = flag.String("proc-root", procRoot, procRootUsage)
+	var readerSocketFile = flag.String("reader-socket", "", "Read restricted /proc files through the privileged reader helper on this socket.")
+	var dockerSocketFile = flag.String("docker-socket", dockerSocket, "Docker API socket used to resolve container:<name> targets.")
+	var auditLogFile = flag.String("audit-log", "", "Append an entry for every signal sent from the UI to this file.")
+	var record = flag.String("record", "", "Append every collection cycle to this JSON lines file.")
+	var replay = flag.String("replay", "", "Serve the samples of a -record file instead of collecting.")
+	var duration = flag.Duration("duration", 0, "Stop collecting and exit after this long, e.g. 10m.")
+	var summaryFile = flag.String("summary", "", "On exit write min/max/avg per target and metric as JSON to this file, - for stdout.")
+	var pushURLFlag = flag.String("push-url", "", "Agent mode: POST the samples to the /api/v1/push endpoint of a central exporter at this URL.")
+	var pushTokenFile = flag.String("push-token-file", "", "File with the bearer token sent with -push-url.")
+	var pushIntervalFlag = flag.Duration("push-interval", pushInterval, "How often to send the samples with -push-url.")
+	var rateLimitFlag = flag.Float64("rate-limit", 0, "Requests per second allowed per client IP, with bursts of twice that; 0 for no limit.")
+	var maxExpensive = flag.Int("max-expensive-requests", 4, "Concurrent range queries, exports, summaries and fd listings allowed; 0 for no limit.")
+	var procConnector = flag.Bool("proc-connector", false, "Keep the process table up to date from kernel fork/exec/exit events instead of scanning /proc every cycle; older kernels require CAP_NET_ADMIN.")
+	var receive = flag.Bool("receive", false, "Accept samples from agents on /api/v1/push.")
+	var runAs = flag.String("run-as", "", "When started as root, switch to this user[:group] after opening the listeners.")
+	var keepCaps = flag.String("keep-caps", "", "Comma separated capabilities kept by -run-as: sys_ptrace, dac_read_search.")
+	var listen = flag.String("listen", ":8090", "Comma separated listen addresses, e.g. [::]:8090 (dual-stack), tcp4:0.0.0.0:8090, tcp6:[::]:8090, [fe80::1%eth0]:8090, unix:/run/proc-exporter.sock?mode=0660&group=www-data. Not used with systemd socket activation.")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(GetBuildInfo())
+		return
+	}
+	defaultTargets = strings.Split(*names, ",")
+	configFile = *cfgFile
+	auditLog = *auditLogFile
+	procRoot = *procRootFlag
+	readerSocket = *readerSocketFile
+	dockerSocket = *dockerSocketFile
+	fdsAPIEnabled = *enableFdsAPI
+	pushURL = *pushURLFlag
+	pushInterval = *pushIntervalFlag
+	receiveMode = *receive
+	rateLimit = *rateLimitFlag
+	if *maxExpensive > 0 {
+		expensiveSlots = make(chan struct{}, *maxExpensive)
+	}
+	if pushInterval <= 0 {
+		fmt.Println("-push-interval must be positive")
+		os.Exit(2)
+	}
+	listeners, err := OpenListeners(*listen)
+	check(err)
+	if *runAs != "" {
+		check(dropPrivileges(*runAs, *keepCaps, listeners))
+		fmt.Println("running as uid", os.Geteuid(), "gid", os.Getegid())
+	}
+	if access := GetProcAccess(); access.Limited {
+		fmt.Println("warning:", access.Message)
+	}
+	var cfg Config
+	if configFile != "" {
+		var err error
+		cfg, err = LoadConfig(configFile)
+		check(err)
+	}
+	check(ApplyConfig(cfg))
+	if *procConnector {
+		if err := StartProcConnector(); err != nil {
+			fmt.Println("warning: proc connector:", err, "- scanning /proc every cycle instead")
+		}
+	}
+	if *htpasswdFile != "" {
+		users, err := LoadHtpasswd(*htpasswdFile)
+		check(err)
+		basicAuthUsers = users
+	}
+	if *authUser != "" {
+		if *authPasswordFile == "" {
+			fmt.Println("-auth-user requires -auth-password-file")
+			os.Exit(2)
+		}
+		password, err := ReadPasswordFile(*authPasswordFile)
+		check(err)
+		basicAuthUsers[*authUser] = password
+	}
+	if *tokenFile != "" {
+		tokens, err := LoadTokens(*tokenFile)
+		check(err)
+		bearerTokens = tokens
+	}
+	if receiveMode && !authConfigured() {
+		fmt.Println("-receive requires -auth-user, -auth-htpasswd or -auth-token-file")
+		os.Exit(2)
+	}
+	if *pushTokenFile != "" {
+		token, err := ReadPasswordFile(*pushTokenFile)
+		check(err)
+		pushToken = token
+	}
+	if *summaryFile != "" {
+		summaries = make(map[string]map[string]*MetricSummary)
+		onShutdown(func() {
+			if err := WriteSummary(*summaryFile); err != nil {
+				fmt.Println("summary:", err)
+			}
+		})
+	}
+	if *record != "" {
+		check(StartRecording(*record))
+	}
+	stopCollector := make(chan struct{})
+	collectorDone := make(chan struct{})
+	if *replay != "" {
+		check(LoadReplay(*replay))
+		fmt.Println("replaying", *replay, "up to", replayEnd)
+		close(collectorDone)
+	} else {
+		go func() {
+			MonitorProcessStats(stopCollector)
+			close(collectorDone)
+		}()
+	}
+	go RunFederation()
+	stopPushgateway := make(chan struct{})
+	pushgatewayDone := make(chan struct{})
+	go func() {
+		RunPushgateway(stopPushgateway)
+		close(pushgatewayDone)
+	}()
+	onShutdown(func() {
+		close(stopPushgateway)
+		<-pushgatewayDone
+	})
+	if pushURL != "" {
+		go RunAgent()
+	}
+
+	if *enablePprof {
+		go servePprof(*pprofAddress)
+	}
 
-	http.HandleFunc("/hello", hello)
-	http.HandleFunc("/headers", headers)
-	http.HandleFunc("/", mainPage)
-	fmt.Println("listening on 8090")
+	// Not the DefaultServeMux, which net/http/pprof registers itself on.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", hello)
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/headers", headers)
+	mux.HandleFunc("/inventory", limitExpensive(inventory))
+	mux.HandleFunc("/targets", targets)
+	mux.HandleFunc("/process/", processPage)
+	mux.HandleFunc("/map", mapPage)
+	mux.HandleFunc("/api/v1/map", limitExpensive(dependencyMapHandler))
+	mux.HandleFunc("/metrics", metrics)
+	mux.HandleFunc("/api/v1/anomalies", anomaliesHandler)
+	mux.HandleFunc("/api/v1/events", eventsHandler)
+	mux.HandleFunc("/api/v1/query", limitExpensive(queryHandler))
+	mux.HandleFunc("/api/v1/export", limitExpensive(exportHandler))
+	mux.HandleFunc("/api/v1/grafana-dashboard", grafanaDashboardHandler)
+	mux.HandleFunc("/api/v1/grafana/", limitExpensive(grafanaJSONHandler))
+	mux.HandleFunc("/api/v1/summary", limitExpensive(summaryHandler))
+	mux.HandleFunc("/api/v1/push", pushHandler)
+	mux.HandleFunc("/api/v1/processes", processesHandler)
+	mux.HandleFunc("/api/v1/metrics", metricSelectionHandler)
+	mux.HandleFunc("/api/v1/config", configHandler)
+	mux.HandleFunc("/api/v1/processes/", processHandler)
+	mux.HandleFunc("/prometheus", prometheusHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/debug/state", debugState)
+	mux.HandleFunc("/-/reload", reload)
+	mux.HandleFunc("/", mainPage)
+	srv := &http.Server{Handler: limitRate(requireAuth(compressResponses(mux)))}
+	for _, l := range listeners {
+		fmt.Println("listening on", l.Addr())
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}(l)
+	}
 
-	http.ListenAndServe(":8090", nil)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+loop:
+	for {
+		select {
+		case <-deadline:
+			fmt.Println("ran for", *duration, "shutting down")
+			break loop
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				fmt.Println("received", sig, "shutting down")
+				break loop
+			}
+			if err := ReloadConfig(); err != nil {
+				fmt.Println("reload failed:", err)
+			} else {
+				fmt.Println("configuration reloaded")
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Println("shutdown:", err)
+	}
+	close(stopCollector)
+	<-collectorDone
+	runShutdownHooks()
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SignalAction allows sending Signal to a target from its detail page,
+// e.g. SIGQUIT for a JVM thread dump. An empty Process allows it for every
+// target.
+type SignalAction struct {
+	Process string `json:"process,omitempty"`
+	Signal  string `json:"signal"`
+	Label   string `json:"label,omitempty"`
+}
+
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Remote  string    `json:"remote"`
+	Action  string    `json:"action"`
+	Process string    `json:"process"`
+	Pid     int       `json:"pid"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var (
+	signalActions []SignalAction
+	// auditLog is the -audit-log file, entries are also printed.
+	auditLog string
+)
+
+// SignalActionsFor returns the actions allowed for a target.
+func SignalActionsFor(processName string) []SignalAction {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	var result []SignalAction
+	for _, a := range signalActions {
+		if a.Process == "" || a.Process == processName {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func requestUser(req *http.Request) string {
+	if user, _, ok := req.BasicAuth(); ok {
+		return user
+	}
+	if strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return "bearer token"
+	}
+	return ""
+}
+
+func audit(e AuditEntry) {
+	line, _ := json.Marshal(e)
+	fmt.Println("audit:", string(line))
+	if auditLog == "" {
+		return
+	}
+	f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Println("audit log:", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// sameOriginJSON reports whether req can't be a cross-site form post, which
+// a browser logged in with basic auth would send with its credentials: forms
+// can't send application/json, and browsers mark cross-site requests in
+// Sec-Fetch-Site.
+func sameOriginJSON(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return false
+	}
+	site := req.Header.Get("Sec-Fetch-Site")
+	return site == "" || site == "same-origin" || site == "none"
+}
+
+// signalHandler serves POST /api/v1/processes/{name}/signal with
+// {"signal": "SIGQUIT"}, sending it to the first pid of the target if a
+// configured action allows it and that pid is still the target when the
+// signal goes out. Like /debug/state it needs auth configured.
+func signalHandler(w http.ResponseWriter, req *http.Request, processName string) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authConfigured() {
+		http.Error(w, "signals require -auth-user, -auth-htpasswd or -auth-token-file", http.StatusForbidden)
+		return
+	}
+	if !sameOriginJSON(req) {
+		http.Error(w, "signals require Content-Type: application/json from the same site", http.StatusForbidden)
+		return
+	}
+	var body struct {
+		Signal string `json:"signal"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, `expected {"signal": "<name>"}`, http.StatusBadRequest)
+		return
+	}
+	allowed := false
+	for _, a := range SignalActionsFor(processName) {
+		if a.Signal == body.Signal {
+			allowed = true
+		}
+	}
+	if !allowed {
+		http.Error(w, body.Signal+" is not configured for "+processName, http.StatusForbidden)
+		return
+	}
+	pids := ResolveTarget(processName)
+	if len(pids) == 0 {
+		http.Error(w, processName+" is not running", http.StatusNotFound)
+		return
+	}
+	e := AuditEntry{
+		Time:    time.Now(),
+		User:    requestUser(req),
+		Remote:  req.RemoteAddr,
+		Action:  body.Signal,
+		Process: processName,
+		Pid:     pids[0],
+	}
+	err := sendSignal(pids[0], body.Signal, func() error {
+		expireScan()
+		for _, pid := range ResolveTarget(processName) {
+			if pid == pids[0] {
+				return nil
+			}
+		}
+		return fmt.Errorf("pid %d is no longer %s", pids[0], processName)
+	})
+	if err != nil {
+		e.Error = err.Error()
+	}
+	audit(e)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestSendSignalVerify(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	gone := errors.New("no longer the target")
+	if err := sendSignal(pid, "SIGTERM", func() error { return gone }); err != gone {
+		t.Fatalf("sendSignal = %v, want the verify error", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("signal sent although verify failed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := sendSignal(pid, "SIGTERM", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM not delivered")
+	}
+}
@@ -0,0 +1,69 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGSTOP": syscall.SIGSTOP,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+func checkSignal(name string) error {
+	if _, ok := signalNames[name]; !ok {
+		return fmt.Errorf("unknown signal %q", name)
+	}
+	return nil
+}
+
+// sendSignal sends the signal name to pid once verify, which resolves the
+// target again, still finds it there, and only if its start time hasn't
+// changed meanwhile. Where the kernel has pidfds the process is pinned
+// first, so the signal can't reach a process that got the pid since.
+func sendSignal(pid int, name string, verify func() error) error {
+	sig, ok := signalNames[name]
+	if !ok {
+		return fmt.Errorf("unknown signal %q", name)
+	}
+	started, err := pidStartTime(pid)
+	if err != nil {
+		return err
+	}
+	fd, err := pidfdOpen(pid)
+	if errors.Is(err, syscall.ESRCH) {
+		return err
+	} else if err == nil {
+		defer syscall.Close(fd)
+	}
+	if err := verify(); err != nil {
+		return err
+	}
+	if now, err := pidStartTime(pid); err != nil || !now.Equal(started) {
+		return fmt.Errorf("pid %d exited before the signal was sent", pid)
+	}
+	if fd >= 0 {
+		return pidfdSendSignal(fd, sig)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+func pidStartTime(pid int) (time.Time, error) {
+	m, err := GetPidStats(pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return GetStartTime(atoi64(m["starttime"])), nil
+}
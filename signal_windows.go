@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkSignal only accepts SIGKILL, which is sent by terminating the
+// process; Windows has no other signals to send.
+func checkSignal(name string) error {
+	if name != "SIGKILL" {
+		return fmt.Errorf("signal %q can't be sent on Windows, only SIGKILL", name)
+	}
+	return nil
+}
+
+// sendSignal terminates pid once verify, which resolves the target again,
+// still finds it there. The process handle is opened first, and keeps
+// referring to that process even if the pid is reused.
+func sendSignal(pid int, name string, verify func() error) error {
+	if err := checkSignal(name); err != nil {
+		return err
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	if err := verify(); err != nil {
+		return err
+	}
+	return p.Kill()
+}
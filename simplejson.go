@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Grafana JSON datasource (SimpleJSON) API under /api/v1/grafana/: a
+// GET of the base URL tests the connection, /search lists the series,
+// /query returns them and /annotations the lifecycle events. A series is
+// "<target>:<metric>", split at the last colon as target names have colons
+// of their own, e.g. "unit:nginx.service:cpu".
+
+type grafanaRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// millis returns the range in unix ms, zero for a bound that doesn't parse.
+func (r grafanaRange) millis() (int64, int64) {
+	ms := func(s string) int64 {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return ms(r.From), ms(r.To)
+}
+
+type grafanaQuery struct {
+	Range      grafanaRange `json:"range"`
+	IntervalMs int64        `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target string `json:"target"`
+	// Datapoints are [value, unix ms] pairs.
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaAnnotationQuery struct {
+	Range grafanaRange `json:"range"`
+	// Annotation is returned with every annotation as is, its "query"
+	// limits the events to one target.
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+	Tags       []string    `json:"tags"`
+}
+
+func splitSeries(series string) (string, string) {
+	i := strings.LastIndexByte(series, ':')
+	if i < 0 {
+		return series, ""
+	}
+	return series[:i], series[i+1:]
+}
+
+// GrafanaSearch returns the series containing filter, every one if empty.
+func GrafanaSearch(filter string) []string {
+	metrics := GetMetricSelection().Available
+	result := []string{}
+	for _, name := range TargetNames() {
+		for _, m := range metrics {
+			if s := name + ":" + m; strings.Contains(s, filter) {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// GrafanaQuery returns the series of q averaged to its interval. Unknown
+// series are returned without datapoints so one doesn't fail the panel.
+func GrafanaQuery(q grafanaQuery) []grafanaSeries {
+	from, to := q.Range.millis()
+	var step string
+	if q.IntervalMs > 0 {
+		step = (time.Duration(q.IntervalMs) * time.Millisecond).String()
+	}
+	result := []grafanaSeries{}
+	for _, t := range q.Targets {
+		if t.Target == "" {
+			continue
+		}
+		process, metric := splitSeries(t.Target)
+		r := RunQuery(Query{Process: process, Metric: metric, From: from, To: to, Step: step})
+		series := grafanaSeries{Target: t.Target, Datapoints: make([][2]float64, 0, len(r.Points))}
+		for _, p := range r.Points {
+			series.Datapoints = append(series.Datapoints, [2]float64{p[1], p[0]})
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+// GrafanaAnnotations returns the lifecycle events in the range of q.
+func GrafanaAnnotations(q grafanaAnnotationQuery) []grafanaAnnotation {
+	from, to := q.Range.millis()
+	var annotation struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(q.Annotation, &annotation)
+	result := []grafanaAnnotation{}
+	for _, e := range GetEvents(annotation.Query) {
+		ms := e.Time.UnixNano() / int64(time.Millisecond)
+		if (from > 0 && ms < from) || (to > 0 && ms > to) {
+			continue
+		}
+		text := "pid " + strconv.Itoa(e.Pid)
+		if e.OldPid != 0 {
+			text += ", was " + strconv.Itoa(e.OldPid)
+		}
+		if e.Error != "" {
+			text += ": " + e.Error
+		}
+		result = append(result, grafanaAnnotation{
+			Annotation: q.Annotation,
+			Time:       ms,
+			Title:      e.Process + " " + e.Event,
+			Text:       text,
+			Tags:       []string{e.Process, e.Event},
+		})
+	}
+	return result
+}
+
+func grafanaJSONHandler(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/api/v1/grafana")
+	if path == "" || path == "/" {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("ok\n"))
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result interface{}
+	switch path {
+	case "/search":
+		var q struct {
+			Target string `json:"target"`
+		}
+		json.Unmarshal(body, &q)
+		result = GrafanaSearch(q.Target)
+	case "/query":
+		var q grafanaQuery
+		if err := json.Unmarshal(body, &q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = GrafanaQuery(q)
+	case "/annotations":
+		var q grafanaAnnotationQuery
+		if err := json.Unmarshal(body, &q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = GrafanaAnnotations(q)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
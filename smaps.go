@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"os"
+	"strings"
+)
+
+// smapsMetrics break rss down by kind of memory from smaps_rollup,
+// reported with "smaps": true. Reading it walks every mapping of the
+// process under its mmap lock, so it is off by default. smaps_anon and
+// smaps_file add up to rss; smaps_shared and smaps_locked overlap them.
+var smapsMetrics = []string{
+	"smaps_anon",
+	"smaps_file",
+	"smaps_shared",
+	"smaps_locked",
+}
+
+var smapsEnabled bool
+
+func init() {
+	for _, name := range smapsMetrics {
+		name := name
+		procmon.Register(procmon.Metric{MetricName: name, Files: []string{"smaps_rollup"}, Read: func(pid int) (int64, error) {
+			if !smapsEnabled {
+				return 0, procmon.ErrUnavailable
+			}
+			m, err := cached(pid, "smaps", func() (interface{}, error) {
+				return GetSmapsRollup(pid)
+			})
+			if err != nil {
+				return 0, err
+			}
+			return m.(map[string]int64)[name], nil
+		}})
+	}
+}
+
+// GetSmapsRollup returns the smaps metrics of pid in bytes.
+func GetSmapsRollup(pid int) (map[string]int64, error) {
+	dat, err := pidFile(pid, "smaps_rollup")
+	if os.IsNotExist(err) {
+		// before Linux 4.14
+		return nil, procmon.ErrUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	kb := make(map[string]int64)
+	for _, line := range strings.Split(string(dat), "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			kb[line[:i]] = atoi64(strings.TrimSuffix(strings.TrimSpace(line[i+1:]), " kB"))
+		}
+	}
+	return map[string]int64{
+		"smaps_anon":   kb["Anonymous"] * 1024,
+		"smaps_file":   (kb["Rss"] - kb["Anonymous"]) * 1024,
+		"smaps_shared": (kb["Shared_Clean"] + kb["Shared_Dirty"]) * 1024,
+		"smaps_locked": kb["Locked"] * 1024,
+	}, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"strconv"
+)
+
+// socketMetrics are the per process socket counts, reported with
+// "sockets": true. They need the socket tables of the process' network
+// namespace on every collection, which is costly on hosts with many
+// connections.
+var socketMetrics = []string{
+	"tcp_established",
+	"tcp_time_wait",
+	"tcp_listen",
+	"udp_sockets",
+}
+
+var socketsEnabled bool
+
+func init() {
+	for _, name := range socketMetrics {
+		name := name
+		procmon.Register(procmon.Metric{MetricName: name, Files: []string{"fd/", "net/tcp", "net/tcp6", "net/udp", "net/udp6"}, Read: func(pid int) (int64, error) {
+			if !socketsEnabled {
+				return 0, procmon.ErrUnavailable
+			}
+			m, err := cached(pid, "sockets", func() (interface{}, error) {
+				return GetSocketStats(pid)
+			})
+			if err != nil {
+				return 0, err
+			}
+			return m.(map[string]int64)[name], nil
+		}})
+	}
+}
+
+// GetSocketStats counts the TCP connections by state and the UDP sockets of
+// pid, matching the socket inodes of its fds against the tables of its
+// network namespace. TIME_WAIT connections no longer belong to an fd, so
+// those on a port pid listens on are counted, i.e. the ones it closed as a
+// server.
+func GetSocketStats(pid int) (map[string]int64, error) {
+	inodes, err := socketInodes(pid)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, inode := range inodes {
+		owned[inode] = true
+	}
+	dir := procRoot + "/" + strconv.Itoa(pid)
+	m := make(map[string]int64)
+	for _, name := range socketMetrics {
+		m[name] = 0
+	}
+	tcp := readNetSockets(dir, "tcp", "tcp6")
+	listening := make(map[int]bool)
+	for _, s := range tcp {
+		if !owned[s.inode] {
+			continue
+		}
+		switch s.state {
+		case tcpEstablished:
+			m["tcp_established"]++
+		case tcpListen:
+			m["tcp_listen"]++
+			listening[s.localPort] = true
+		}
+	}
+	for _, s := range tcp {
+		if s.state == tcpTimeWait && listening[s.localPort] {
+			m["tcp_time_wait"]++
+		}
+	}
+	for _, s := range readNetSockets(dir, "udp", "udp6") {
+		if owned[s.inode] {
+			m["udp_sockets"]++
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type MetricSummary struct {
+	Min     int64   `json:"min"`
+	Max     int64   `json:"max"`
+	Avg     float64 `json:"avg"`
+	Samples int     `json:"samples"`
+	sum     float64
+}
+
+// summaries accumulates every sample per target and metric for -summary,
+// independent of how many samples are retained. nil when not enabled.
+var summaries map[string]map[string]*MetricSummary
+
+// addToSummary is called by collectAll with statsLock held.
+func addToSummary(processName string, s Sample) {
+	if summaries == nil {
+		return
+	}
+	metrics := summaries[processName]
+	if metrics == nil {
+		metrics = make(map[string]*MetricSummary)
+		summaries[processName] = metrics
+	}
+	for name, v := range s.Metrics {
+		m := metrics[name]
+		if m == nil {
+			m = &MetricSummary{Min: v, Max: v}
+			metrics[name] = m
+		}
+		if v < m.Min {
+			m.Min = v
+		}
+		if v > m.Max {
+			m.Max = v
+		}
+		m.sum += float64(v)
+		m.Samples++
+		m.Avg = m.sum / float64(m.Samples)
+	}
+}
+
+// WriteSummary writes the summaries as JSON to filename, or stdout for "-".
+func WriteSummary(filename string) error {
+	statsLock.RLock()
+	dat, err := json.MarshalIndent(summaries, "", "  ")
+	statsLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	dat = append(dat, '\n')
+	if filename == "-" {
+		_, err = os.Stdout.Write(dat)
+		return err
+	}
+	return ioutil.WriteFile(filename, dat, 0644)
+}
+
+// WindowSummary summarizes the samples of one metric over a window of the
+// retained history. Percentiles are nearest-rank.
+type WindowSummary struct {
+	MetricSummary
+	Last int64 `json:"last"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+}
+
+func percentile(sorted []int64, p float64) int64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// SummarizeSamples summarizes every metric of samples taken at or after
+// from (unix ms).
+func SummarizeSamples(samples []Sample, from int64) map[string]WindowSummary {
+	values := make(map[string][]int64)
+	for _, s := range samples {
+		if s.Time < from {
+			continue
+		}
+		for name, v := range s.Metrics {
+			values[name] = append(values[name], v)
+		}
+	}
+	result := make(map[string]WindowSummary, len(values))
+	for name, vs := range values {
+		ws := WindowSummary{Last: vs[len(vs)-1]}
+		var sum float64
+		for _, v := range vs {
+			sum += float64(v)
+		}
+		ws.Samples = len(vs)
+		ws.Avg = sum / float64(len(vs))
+		sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+		ws.Min, ws.Max = vs[0], vs[len(vs)-1]
+		ws.P50 = percentile(vs, 50)
+		ws.P90 = percentile(vs, 90)
+		ws.P99 = percentile(vs, 99)
+		result[name] = ws
+	}
+	return result
+}
+
+// summaryHandler answers GET /api/v1/summary?window=5m with the
+// WindowSummary per target and metric, everything retained without window.
+// ?process= and ?metric= (comma separated) restrict it like on /metrics.
+func summaryHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	var from int64
+	if window := q.Get("window"); window != "" {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			http.Error(w, "bad window: "+window, http.StatusBadRequest)
+			return
+		}
+		from = currentTime().Add(-d).UnixNano() / int64(time.Millisecond)
+	}
+	history := GetMetrics()
+	for name, samples := range GetFederatedMetrics() {
+		history[name] = samples
+	}
+	var processes, metrics map[string]bool
+	if list := q.Get("process"); list != "" {
+		processes = make(map[string]bool)
+		for _, name := range strings.Split(list, ",") {
+			processes[name] = true
+		}
+	}
+	if list := q.Get("metric"); list != "" {
+		metrics = make(map[string]bool)
+		for _, name := range strings.Split(list, ",") {
+			metrics[name] = true
+		}
+	}
+	result := make(map[string]map[string]WindowSummary)
+	for name, samples := range history {
+		if processes != nil && !processes[name] {
+			continue
+		}
+		summary := SummarizeSamples(samples, from)
+		for m := range summary {
+			if metrics != nil && !metrics[m] {
+				delete(summary, m)
+			}
+		}
+		result[name] = summary
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unitCgroup returns the cgroup directory of a systemd unit, in the
+// unified hierarchy or the v1 name=systemd one. A bare unit name is looked
+// for in system.slice, anything else is a path below the hierarchy, e.g.
+// "user.slice/user-1000.slice/user@1000.service/app.slice/foo.service".
+func unitCgroup(unit string) string {
+	if !strings.Contains(unit, "/") {
+		unit = "system.slice/" + unit
+	}
+	for _, root := range []string{cgroupRoot, cgroupRoot + "/unified", cgroupRoot + "/systemd"} {
+		dir := root + "/" + unit
+		if _, err := ioutil.ReadFile(dir + "/cgroup.procs"); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// MainPIDs are looked up by a background goroutine, as containers are, so
+// that collection cycles never wait for systemctl. A unit's MainPID is
+// looked up again at most every unitMainPidRecheck while it isn't in the
+// unit's cgroup, and no longer once the unit hasn't been asked for in
+// unitMainForget.
+var (
+	unitMainRefresh    = time.Second
+	unitMainPidRecheck = 10 * time.Second
+	unitMainForget     = time.Minute
+	unitMainLock       sync.Mutex
+	unitMains          = make(map[string]unitMain)
+	unitMainWake       = make(chan struct{}, 1)
+	unitMainStart      sync.Once
+)
+
+type unitMain struct {
+	pid     int
+	checked time.Time
+	// stale is set once pid has left the cgroup, e.g. on a restart
+	stale  bool
+	wanted time.Time
+}
+
+// unitMainPid returns the MainPID of unit from systemctl, 0 if it has none,
+// e.g. a oneshot service or a unit of a user manager.
+func unitMainPid(unit string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	name := unit[strings.LastIndex(unit, "/")+1:]
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "-p", "MainPID", "--value", "--", name).Output()
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return pid
+}
+
+// unitPids returns the main process of a systemd unit, its MainPID as last
+// looked up. Without one, or until the first lookup is done, it is the member of the cgroup whose parent is
+// not, i.e. was started or adopted by systemd; helpers it spawns have the
+// main process or another member as parent.
+func unitPids(unit string) []int {
+	dir := unitCgroup(unit)
+	if dir == "" {
+		return nil
+	}
+	dat, err := ioutil.ReadFile(dir + "/cgroup.procs")
+	if err != nil {
+		return nil
+	}
+	members := make(map[int]bool)
+	for _, line := range strings.Fields(string(dat)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			members[pid] = true
+		}
+	}
+	unitMainStart.Do(func() { go runUnitMainResolver() })
+	unitMainLock.Lock()
+	m := unitMains[unit]
+	m.wanted = time.Now()
+	if !members[m.pid] {
+		m.stale = true
+	}
+	unitMains[unit] = m
+	unitMainLock.Unlock()
+	if m.stale && time.Since(m.checked) >= unitMainPidRecheck {
+		select {
+		case unitMainWake <- struct{}{}:
+		default:
+		}
+	}
+	if members[m.pid] {
+		return []int{m.pid}
+	}
+	var pids []int
+	for pid := range members {
+		if !members[parentPid(pid)] {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func runUnitMainResolver() {
+	ticker := time.NewTicker(unitMainRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-unitMainWake:
+		}
+		var units []string
+		unitMainLock.Lock()
+		for unit, m := range unitMains {
+			if time.Since(m.wanted) > unitMainForget {
+				delete(unitMains, unit)
+				continue
+			}
+			if m.stale && time.Since(m.checked) >= unitMainPidRecheck {
+				units = append(units, unit)
+			}
+		}
+		unitMainLock.Unlock()
+		for _, unit := range units {
+			pid := unitMainPid(unit)
+			unitMainLock.Lock()
+			if m, ok := unitMains[unit]; ok {
+				m.pid, m.checked, m.stale = pid, time.Now(), false
+				unitMains[unit] = m
+			}
+			unitMainLock.Unlock()
+		}
+	}
+}
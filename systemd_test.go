@@ -0,0 +1,77 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeUnit makes a cgroup for unit holding the nginx processes of
+// testdata/proc, 100 and its child 101, and a systemctl printing mainPid.
+func fakeUnit(t *testing.T, unit, mainPid string) {
+	useTestProc(t)
+	dir := t.TempDir()
+	cg := filepath.Join(dir, "cgroup", "system.slice", unit)
+	if err := os.MkdirAll(cg, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cg, "cgroup.procs"), []byte("100\n101\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0755)
+	if err := ioutil.WriteFile(filepath.Join(bin, "systemctl"), []byte("#!/bin/sh\necho "+mainPid+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldRoot, oldPath := cgroupRoot, os.Getenv("PATH")
+	cgroupRoot = filepath.Join(dir, "cgroup")
+	os.Setenv("PATH", bin)
+	t.Cleanup(func() {
+		cgroupRoot = oldRoot
+		os.Setenv("PATH", oldPath)
+		unitMainLock.Lock()
+		delete(unitMains, unit)
+		unitMainLock.Unlock()
+	})
+}
+
+// resolvedUnitPids returns unitPids once the MainPID of unit was looked up
+// in the background.
+func resolvedUnitPids(t *testing.T, unit string) []int {
+	unitPids(unit)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		unitMainLock.Lock()
+		m := unitMains[unit]
+		unitMainLock.Unlock()
+		if !m.checked.IsZero() {
+			return unitPids(unit)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("MainPID of %s not looked up", unit)
+	return nil
+}
+
+func TestUnitPidsMainPid(t *testing.T) {
+	fakeUnit(t, "web.service", "101")
+	if got := unitPids("web.service"); !reflect.DeepEqual(got, []int{100}) {
+		t.Errorf("unitPids before the lookup = %v, want the member started by systemd [100]", got)
+	}
+	if got := resolvedUnitPids(t, "web.service"); !reflect.DeepEqual(got, []int{101}) {
+		t.Errorf("unitPids = %v, want the MainPID [101]", got)
+	}
+}
+
+func TestUnitPidsWithoutMainPid(t *testing.T) {
+	fakeUnit(t, "batch.service", "0")
+	if got := resolvedUnitPids(t, "batch.service"); !reflect.DeepEqual(got, []int{100}) {
+		t.Errorf("unitPids = %v, want the member started by systemd [100]", got)
+	}
+}
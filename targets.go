@@ -0,0 +1,97 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+type TargetStatus struct {
+	Name       string    `json:"name"`
+	Pids       []int     `json:"pids"`
+	LastScrape time.Time `json:"last_scrape"`
+	LastError  string    `json:"last_error,omitempty"`
+	Samples    int       `json:"samples"`
+	// MetricErrors has the metrics that couldn't be read in the last
+	// collection, e.g. {"read_bytes": "EACCES"}.
+	MetricErrors map[string]string `json:"metric_errors,omitempty"`
+}
+
+func (t TargetStatus) Up() bool {
+	return len(t.Pids) > 0 && t.LastError == ""
+}
+
+func GetTargetStatus() []TargetStatus {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	var result []TargetStatus
+	for name, ps := range statsMap {
+		var metricErrors map[string]string
+		for m, e := range ps.MetricErrors {
+			if selectedMetrics != nil && !selectedMetrics[m] {
+				continue
+			}
+			if metricErrors == nil {
+				metricErrors = make(map[string]string)
+			}
+			metricErrors[m] = e
+		}
+		result = append(result, TargetStatus{
+			Name:         name,
+			Pids:         ps.Pids,
+			LastScrape:   ps.LastScrape,
+			LastError:    ps.LastError,
+			Samples:      ps.Samples.Len(),
+			MetricErrors: metricErrors,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+var targetsTemplate = template.Must(template.New("targets").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Millisecond).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Targets</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.up { color: #080; }
+.down { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Targets</h1>
+<table>
+<tr><th>Target</th><th>State</th><th>PIDs</th><th>Last scrape</th><th>Samples</th><th>Last error</th><th>Unreadable metrics</th></tr>
+{{range .}}
+<tr>
+<td><a href="/process/{{pathEscape .Name}}">{{.Name}}</a></td>
+{{if .Up}}<td class="up">UP</td>{{else}}<td class="down">DOWN</td>{{end}}
+<td>{{range $i, $p := .Pids}}{{if $i}}, {{end}}{{$p}}{{end}}</td>
+<td>{{ago .LastScrape}}</td>
+<td>{{.Samples}}</td>
+<td>{{.LastError}}</td>
+<td>{{range $m, $e := .MetricErrors}}{{$m}}: {{$e}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+func targets(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	targetsTemplate.Execute(w, GetTargetStatus())
+}
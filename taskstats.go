@@ -0,0 +1,33 @@
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+// taskstatsMetrics are the delay accounting totals of a process in
+// nanoseconds, from the netlink taskstats interface, reported with
+// "taskstats": true: the time its threads waited for a CPU, for block I/O
+// and for swapping in.
+var taskstatsMetrics = []string{
+	"taskstats_cpu_delay",
+	"taskstats_blkio_delay",
+	"taskstats_swapin_delay",
+}
+
+var taskstatsEnabled bool
+
+func init() {
+	for i, name := range taskstatsMetrics {
+		i := i
+		procmon.Register(procmon.Metric{MetricName: name, Counter: true, Read: func(pid int) (int64, error) {
+			if !taskstatsEnabled {
+				return 0, procmon.ErrUnavailable
+			}
+			v, err := cached(pid, "taskstats", func() (interface{}, error) {
+				return GetTaskstats(pid)
+			})
+			if err != nil {
+				return 0, err
+			}
+			return v.([]int64)[i], nil
+		}})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/colmo23/linux-proc-exporter/pkg/procmon"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// generic netlink and taskstats constants from linux/genetlink.h and
+// linux/taskstats.h
+const (
+	genlIdCtrl            = 0x10
+	ctrlCmdGetFamily      = 3
+	ctrlAttrFamilyId      = 1
+	ctrlAttrFamilyName    = 2
+	taskstatsCmdGet       = 1
+	taskstatsCmdAttrTgid  = 2
+	taskstatsTypeStats    = 3
+	taskstatsTypeAggrTgid = 5
+)
+
+var taskstatsConn struct {
+	sync.Mutex
+	fd     int
+	family uint16
+	seq    uint32
+}
+
+// GetTaskstats returns the cpu, block I/O and swap-in delay totals of the
+// thread group pid in nanoseconds. Without delay accounting, i.e.
+// kernel.task_delayacct=0, the block I/O and swap-in delays are always 0.
+func GetTaskstats(pid int) ([]int64, error) {
+	taskstatsConn.Lock()
+	defer taskstatsConn.Unlock()
+	if taskstatsConn.fd == 0 {
+		if err := openTaskstats(); err != nil {
+			return nil, err
+		}
+	}
+	attrs, err := genlRequest(taskstatsConn.family, taskstatsCmdGet, taskstatsCmdAttrTgid, uint32Bytes(uint32(pid)))
+	if errors.Is(err, syscall.ESRCH) {
+		return nil, procmon.ErrUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	aggr, ok := attrs[taskstatsTypeAggrTgid]
+	if !ok {
+		return nil, errors.New("taskstats: no thread group stats in reply")
+	}
+	stats, ok := netlinkAttrs(aggr)[taskstatsTypeStats]
+	// cpu_delay_total, blkio_delay_total and swapin_delay_total are at the
+	// same offsets since version 1 of struct taskstats
+	if !ok || len(stats) < 64 {
+		return nil, errors.New("taskstats: short stats in reply")
+	}
+	return []int64{
+		int64(nativeEndian.Uint64(stats[24:])),
+		int64(nativeEndian.Uint64(stats[40:])),
+		int64(nativeEndian.Uint64(stats[56:])),
+	}, nil
+}
+
+func openTaskstats() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return fmt.Errorf("taskstats: %w", err)
+	}
+	tv := syscall.Timeval{Sec: 1}
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("taskstats: %w", err)
+	}
+	taskstatsConn.fd = fd
+	attrs, err := genlRequest(genlIdCtrl, ctrlCmdGetFamily, ctrlAttrFamilyName, []byte("TASKSTATS\x00"))
+	if errors.Is(err, syscall.ENOENT) {
+		// kernel built without CONFIG_TASKSTATS
+		err = procmon.ErrUnavailable
+	}
+	if err == nil && len(attrs[ctrlAttrFamilyId]) < 2 {
+		err = errors.New("taskstats: no family id in reply")
+	}
+	if err != nil {
+		closeTaskstats()
+		return err
+	}
+	taskstatsConn.family = nativeEndian.Uint16(attrs[ctrlAttrFamilyId])
+	if dat, err := ioutil.ReadFile(procRoot + "/sys/kernel/task_delayacct"); err == nil && strings.TrimSpace(string(dat)) == "0" {
+		fmt.Println("warning: delay accounting is off, the taskstats block I/O and swap-in delays stay 0 until sysctl kernel.task_delayacct=1")
+	}
+	return nil
+}
+
+func closeTaskstats() {
+	syscall.Close(taskstatsConn.fd)
+	taskstatsConn.fd = 0
+}
+
+// genlRequest sends a generic netlink request with a single attribute and
+// returns the attributes of the reply. On a socket error the connection is
+// closed, to be opened again on the next request.
+func genlRequest(family uint16, cmd uint8, attr uint16, value []byte) (map[uint16][]byte, error) {
+	taskstatsConn.seq++
+	seq := taskstatsConn.seq
+	attrLen := 4 + len(value)
+	msg := make([]byte, syscall.NLMSG_HDRLEN+4+(attrLen+3)&^3)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], family)
+	nativeEndian.PutUint16(msg[6:], syscall.NLM_F_REQUEST)
+	nativeEndian.PutUint32(msg[8:], seq)
+	msg[16] = cmd
+	msg[17] = 1 // version
+	nativeEndian.PutUint16(msg[20:], uint16(attrLen))
+	nativeEndian.PutUint16(msg[22:], attr)
+	copy(msg[24:], value)
+	if err := syscall.Sendto(taskstatsConn.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		closeTaskstats()
+		return nil, fmt.Errorf("taskstats: %w", err)
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := syscall.Recvfrom(taskstatsConn.fd, buf, 0)
+		if err != nil {
+			closeTaskstats()
+			return nil, fmt.Errorf("taskstats: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("taskstats: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				// reply to an earlier request that timed out
+				continue
+			}
+			if m.Header.Type == syscall.NLMSG_ERROR {
+				if len(m.Data) < 4 {
+					return nil, errors.New("taskstats: short error reply")
+				}
+				errno := -int32(nativeEndian.Uint32(m.Data))
+				return nil, fmt.Errorf("taskstats: %w", syscall.Errno(errno))
+			}
+			if len(m.Data) < 4 {
+				return nil, errors.New("taskstats: short reply")
+			}
+			return netlinkAttrs(m.Data[4:]), nil
+		}
+	}
+}
+
+// netlinkAttrs splits b into netlink attributes by type.
+func netlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= 4 {
+		l := int(nativeEndian.Uint16(b))
+		if l < 4 || l > len(b) {
+			break
+		}
+		// without the nested and byte order flags
+		attrs[nativeEndian.Uint16(b[2:])&0x3fff] = b[4:l]
+		next := (l + 3) &^ 3
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return attrs
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, v)
+	return b
+}
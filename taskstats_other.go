@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "github.com/colmo23/linux-proc-exporter/pkg/procmon"
+
+func GetTaskstats(pid int) ([]int64, error) {
+	return nil, procmon.ErrUnavailable
+}
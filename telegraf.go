@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunTelegraf implements the telegraf subcommand for the Telegraf execd
+// input: it writes the samples of every cycle in line protocol to stdout,
+// either for each line Telegraf writes to stdin (signal = "STDIN") or every
+// collection interval (signal = "none"). Everything else goes to stderr.
+func RunTelegraf(args []string) int {
+	fs := flag.NewFlagSet("telegraf", flag.ExitOnError)
+	names := fs.String("name", "python2", "Comma separated process names to monitor.")
+	cfgFile := fs.String("config", "", "JSON config file with processes and metrics.")
+	signal := fs.String("signal", "stdin", "stdin to collect on every line read from stdin, none to collect every interval.")
+	root := fs.String("proc-root", procRoot, procRootUsage)
+	fs.Parse(args)
+	procRoot = *root
+	if *signal != "stdin" && *signal != "none" {
+		fmt.Fprintln(os.Stderr, "-signal must be stdin or none")
+		return 2
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	// the status lines of the collector must not end up in the output
+	os.Stdout = os.Stderr
+	defaultTargets = strings.Split(*names, ",")
+	configFile = *cfgFile
+	var cfg Config
+	if configFile != "" {
+		var err error
+		cfg, err = LoadConfig(configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if err := ApplyConfig(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	emit := func() error {
+		cycle := collectAll()
+		targets := make([]string, 0, len(cycle))
+		for name := range cycle {
+			targets = append(targets, name)
+		}
+		sort.Strings(targets)
+		for _, name := range targets {
+			out.Write(lineProtocol(name, cycle[name]))
+		}
+		return out.Flush()
+	}
+	// counters need a previous sample
+	collectAll()
+	preflight()
+	if *signal == "stdin" {
+		in := bufio.NewScanner(os.Stdin)
+		for in.Scan() {
+			if err := emit(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		// Telegraf closes stdin when it stops the plugin
+		return 0
+	}
+	statsLock.RLock()
+	ticker := time.NewTicker(collectInterval)
+	statsLock.RUnlock()
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := emit(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
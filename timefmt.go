@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// timeFormat is how the JSON of /metrics and /api/v1/export gives
+// timestamps: "unix_ms" or "rfc3339", see Config.TimeFormat.
+var timeFormat = "unix_ms"
+
+func checkTimeFormat(format string) error {
+	if format != "unix_ms" && format != "rfc3339" {
+		return fmt.Errorf("time_format must be unix_ms or rfc3339, not %q", format)
+	}
+	return nil
+}
+
+// requestTimeFormat returns ?time_format= or else the configured format.
+func requestTimeFormat(req *http.Request) (string, error) {
+	if format := req.URL.Query().Get("time_format"); format != "" {
+		return format, checkTimeFormat(format)
+	}
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+	return timeFormat, nil
+}
+
+// formatMillis formats unix ms as RFC 3339 in UTC with milliseconds.
+func formatMillis(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// sampleRFC3339 is a Sample with an RFC 3339 time.
+type sampleRFC3339 struct {
+	Time    string           `json:"time"`
+	Pid     int              `json:"pid"`
+	Metrics map[string]int64 `json:"metrics"`
+}
+
+func samplesRFC3339(samples []Sample) []sampleRFC3339 {
+	result := make([]sampleRFC3339, len(samples))
+	for i, s := range samples {
+		result[i] = sampleRFC3339{Time: formatMillis(s.Time), Pid: s.Pid, Metrics: s.Metrics}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// MetricMeta tells the UI how to display a metric: multiply the sample
+// value by Scale and format it as Unit ("bytes", "percent", "/s", "state"
+// or "").
+type MetricMeta struct {
+	Unit  string  `json:"unit"`
+	Scale float64 `json:"scale"`
+}
+
+func isCPUMetric(metric string) bool {
+	return metric == "cpu" || strings.HasPrefix(metric, "cpu_core_")
+}
+
+// isDelayMetric reports whether metric is a delay in nanoseconds.
+func isDelayMetric(metric string) bool {
+	return metric == "sched_delay" || strings.HasPrefix(metric, "taskstats_") && strings.HasSuffix(metric, "_delay")
+}
+
+// isDeltaMetric reports whether metric is a per-interval delta rather than
+// a level. Callers hold statsLock.
+func isDeltaMetric(metric string) bool {
+	return collector.IsCounter(metric) || isCPUMetric(metric)
+}
+
+// GetMetricMeta describes metric for the current interval. Callers hold
+// statsLock.
+func GetMetricMeta(metric string) MetricMeta {
+	return metricMeta(metric, collectInterval)
+}
+
+func metricMeta(metric string, interval time.Duration) MetricMeta {
+	seconds := interval.Seconds()
+	switch {
+	case isByteMetric(metric):
+		return MetricMeta{Unit: "bytes", Scale: 1}
+	case isCPUMetric(metric) || metric == "blkio_delay":
+		// ticks in the last interval, 100% is one core
+		return MetricMeta{Unit: "percent", Scale: 100 / (clkTck * seconds)}
+	case isDelayMetric(metric):
+		// nanoseconds waited in the last interval, 100% is one thread
+		// waiting all the time
+		return MetricMeta{Unit: "percent", Scale: 100 / (1e9 * seconds)}
+	case collector.IsCounter(metric):
+		return MetricMeta{Unit: "/s", Scale: 1 / seconds}
+	case metric == "cgroup_cpu_limit" || metric == "fds_pct_used":
+		return MetricMeta{Unit: "percent", Scale: 1}
+	case metric == "state":
+		return MetricMeta{Unit: "state", Scale: 1}
+	}
+	return MetricMeta{Scale: 1}
+}
+
+// formatValueJS formats a sample value with its MetricMeta in the pages.
+const formatValueJS = `function formatValue(v, m) {
+	if (!m) {
+		return v;
+	}
+	v *= m.scale;
+	switch (m.unit) {
+	case "bytes":
+		const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+		let i = 0;
+		while (Math.abs(v) >= 1024 && i < units.length - 1) {
+			v /= 1024;
+			i++;
+		}
+		return +v.toFixed(1) + " " + units[i];
+	case "percent":
+		return +v.toFixed(1) + "%";
+	case "/s":
+		return +v.toFixed(2) + "/s";
+	case "state":
+		return stateNames[Math.round(v)] || "unknown";
+	}
+	return +v.toFixed(2);
+}
+
+// the state metric, in the order of procStateOrder
+const stateNames = ["unknown", "running", "sleeping", "disk sleep", "zombie", "stopped", "tracing stop", "dead", "idle"];
+const stateColors = ["#7f7f7f", "#2ca02c", "#1f77b4", "#ff7f0e", "#d62728", "#9467bd", "#9467bd", "#000000", "#bbbbbb"];
+
+// stateChip returns a colored label for a state metric value.
+function stateChip(v) {
+	const span = document.createElement("span");
+	span.className = "state";
+	span.style.background = stateColors[Math.round(v)] || stateColors[0];
+	span.textContent = formatValue(v, {unit: "state", scale: 1});
+	return span;
+}
+`